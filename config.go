@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/screwdriver-cd/store-cli/sdstore"
+)
+
+// ConfigFile is the project config file of store-cli defaults, read from the current directory or else from $HOME
+const ConfigFile = ".store-cli.yaml"
+
+// ConfigExcludes are the exclude patterns of the config file, applied before those of .storeignore and --exclude
+var ConfigExcludes []string
+
+// configSetting is a setting of the config file. Its flag, then its environment variable, take precedence over
+// the file, which takes precedence over the built-in value
+type configSetting struct {
+	name  string
+	env   string
+	flag  string
+	value string
+}
+
+var configSettings = []configSetting{
+	{name: "scope", flag: "scope"},
+	{name: "type", flag: "type", value: "stable"},
+	{name: "upload-timeout", env: "SD_STORE_CLI_UPLOAD_HTTP_TIMEOUT", flag: "timeout", value: strconv.Itoa(UPLOAD_HTTP_TIMEOUT)},
+	{name: "download-timeout", env: "SD_STORE_CLI_DOWNLOAD_HTTP_TIMEOUT", flag: "timeout", value: strconv.Itoa(DOWNLOAD_HTTP_TIMEOUT)},
+	{name: "remove-timeout", env: "SD_STORE_CLI_REMOVE_HTTP_TIMEOUT", flag: "timeout", value: strconv.Itoa(REMOVE_HTTP_TIMEOUT)},
+	{name: "max-retries", value: strconv.Itoa(MAX_RETRIES)},
+	{name: "retry-wait-min", value: strconv.Itoa(RETRY_WAIT_MIN)},
+	{name: "retry-wait-max", value: strconv.Itoa(RETRY_WAIT_MAX)},
+	{name: "retry-backoff", env: "SD_STORE_RETRY_BACKOFF", value: sdstore.LinearBackoff},
+	{name: "retry-max-time", env: "SD_STORE_RETRY_MAX_TIME", value: "0"},
+	{name: "retry-status-codes", env: "SD_STORE_RETRY_STATUS_CODES"},
+	{name: "compression-level", value: "0"},
+	{name: "exclude", flag: "exclude"},
+	{name: "cache-strategy", env: "SD_CACHE_STRATEGY", value: sdstore.RemoteBackend},
+	{name: "cache-max-size-mb", env: "SD_CACHE_MAX_SIZE_MB", value: "0"},
+	{name: "expect-header", env: "SD_ENABLE_EXPECT_HEADER", value: "false"},
+	{name: "expect-continue-timeout", env: "SD_EXPECT_CONTINUE_TIMEOUT", value: "1"},
+}
+
+// ConfigValue is the effective value of a setting and where it comes from: a flag, an environment variable,
+// the config file or the built-in default
+type ConfigValue struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// storeConfig is the effective config, in the order of configSettings
+type storeConfig struct {
+	values []ConfigValue
+}
+
+// findConfigFile returns the path of the config file in the current directory or else in $HOME, "" when there is none
+func findConfigFile() string {
+	dirs := []string{"."}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, homeDir)
+	}
+
+	for _, dir := range dirs {
+		path, err := filepath.Abs(filepath.Join(dir, ConfigFile))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// loadConfig resolves each setting from the environment, the config file at path, if any, and the built-in values.
+// Flags are resolved by each command
+func loadConfig(path string) (*storeConfig, error) {
+	fileValues := map[string]string{}
+	if path != "" {
+		var err error
+		if fileValues, err = parseConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	config := &storeConfig{}
+	for _, setting := range configSettings {
+		value := ConfigValue{Name: setting.name, Value: setting.value, Source: "default"}
+		if fileValue, ok := fileValues[setting.name]; ok {
+			value.Value, value.Source = fileValue, "file "+path
+		}
+		if setting.env != "" && os.Getenv(setting.env) != "" {
+			value.Value, value.Source = os.Getenv(setting.env), "env "+setting.env
+		}
+		config.values = append(config.values, value)
+	}
+
+	return config, nil
+}
+
+// value returns the effective value of the setting name
+func (c *storeConfig) value(name string) ConfigValue {
+	for _, value := range c.values {
+		if value.Name == name {
+			return value
+		}
+	}
+
+	return ConfigValue{Name: name}
+}
+
+// apply makes the config effective. The environment variables of the settings of the config file are set,
+// other settings set their global
+func (c *storeConfig) apply() error {
+	for _, setting := range configSettings {
+		if value := c.value(setting.name); setting.env != "" && strings.HasPrefix(value.Source, "file ") {
+			_ = os.Setenv(setting.env, value.Value)
+		}
+	}
+	CacheStrategy = strings.ToLower(os.Getenv("SD_CACHE_STRATEGY"))
+	CacheMaxSizeInMB, _ = strconv.ParseInt(os.Getenv("SD_CACHE_MAX_SIZE_MB"), 0, 64)
+
+	for name, global := range map[string]*int{
+		"max-retries":       &MAX_RETRIES,
+		"retry-wait-min":    &RETRY_WAIT_MIN,
+		"retry-wait-max":    &RETRY_WAIT_MAX,
+		"compression-level": &sdstore.ZstdLevel,
+	} {
+		value := c.value(name)
+		n, err := strconv.Atoi(value.Value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s %q from %s, expected a non-negative integer", name, value.Value, value.Source)
+		}
+		*global = n
+	}
+	if sdstore.ZstdLevel > 19 {
+		return fmt.Errorf("invalid compression-level %d, expected 1 to 19, or 0 for the default", sdstore.ZstdLevel)
+	}
+
+	ConfigExcludes = nil
+	for _, pattern := range strings.Split(c.value("exclude").Value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			ConfigExcludes = append(ConfigExcludes, pattern)
+		}
+	}
+
+	return nil
+}
+
+// withFlags returns the effective values once the flags set on the command line, as reported by isSet, are applied
+func (c *storeConfig) withFlags(isSet func(flag string) (string, bool)) []ConfigValue {
+	values := make([]ConfigValue, len(c.values))
+	for i, setting := range configSettings {
+		values[i] = c.value(setting.name)
+		if setting.flag == "" {
+			continue
+		}
+		if flagValue, ok := isSet(setting.flag); ok {
+			values[i].Value, values[i].Source = flagValue, "flag --"+setting.flag
+		}
+	}
+
+	return values
+}
+
+// printConfig writes the effective config to w as a table (text) or as json
+func printConfig(w io.Writer, values []ConfigValue, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(values)
+	case "text", "":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+		for _, value := range values {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", value.Name, value.Value, value.Source)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("invalid format %q, expected text or json", format)
+	}
+}
+
+// parseConfigFile parses the flat YAML mapping of a config file. Values are scalars, or lists given either as
+// "- item" lines or as [a, b], which are returned comma separated
+func parseConfigFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, setting := range configSettings {
+		known[setting.name] = true
+	}
+
+	values := map[string]string{}
+	listKey := ""
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(stripConfigComment(line), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if listKey == "" {
+				return nil, fmt.Errorf("%s:%d: list item outside of a list", path, i+1)
+			}
+			values[listKey] = appendConfigList(values[listKey], unquoteConfigValue(strings.TrimSpace(trimmed[1:])))
+			continue
+		}
+
+		if line != trimmed {
+			return nil, fmt.Errorf("%s:%d: nested settings are not supported", path, i+1)
+		}
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected <setting>: <value>", path, i+1)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !known[key] {
+			return nil, fmt.Errorf("%s:%d: unknown setting %s", path, i+1, key)
+		}
+
+		listKey = ""
+		switch {
+		case value == "":
+			listKey = key
+			values[key] = ""
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			values[key] = ""
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				values[key] = appendConfigList(values[key], unquoteConfigValue(strings.TrimSpace(item)))
+			}
+		default:
+			values[key] = unquoteConfigValue(value)
+		}
+	}
+
+	return values, nil
+}
+
+// stripConfigComment removes a # comment, which starts a line or follows a space outside of quotes
+func stripConfigComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+func unquoteConfigValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+func appendConfigList(list, item string) string {
+	if item == "" {
+		return list
+	}
+	if list == "" {
+		return item
+	}
+
+	return list + "," + item
+}
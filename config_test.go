@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/screwdriver-cd/store-cli/sdstore"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ConfigFile)
+	_ = os.WriteFile(path, []byte(`# store-cli defaults
+scope: event # comment
+type: "cache"
+cache-strategy: 'disk'
+exclude:
+  - "*.log"
+  - .cache/ # temp files
+- "#not-a-comment"
+upload-timeout: 60
+`), 0644)
+
+	values, err := parseConfigFile(path)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	expected := map[string]string{"scope": "event", "type": "cache", "cache-strategy": "disk", "exclude": "*.log,.cache/,#not-a-comment", "upload-timeout": "60"}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("Expected %s to be %q, got %q", name, value, values[name])
+		}
+	}
+
+	_ = os.WriteFile(path, []byte("exclude: [\"*.log\", tmp/]\n"), 0644)
+	if values, err = parseConfigFile(path); err != nil || values["exclude"] != "*.log,tmp/" {
+		t.Errorf("Expected a flow list, got %v, %v", values, err)
+	}
+
+	for _, content := range []string{"unknown: 1\n", "scope event\n", "- item\n", "timeouts:\n  upload: 1\n"} {
+		_ = os.WriteFile(path, []byte(content), 0644)
+		if _, err = parseConfigFile(path); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ConfigFile)
+	_ = os.WriteFile(path, []byte("scope: pipeline\ncache-strategy: disk\nupload-timeout: 60\nmax-retries: 2\nexclude: [\"*.log\"]\n"), 0644)
+
+	os.Setenv("SD_CACHE_STRATEGY", "s3")
+	os.Unsetenv("SD_STORE_CLI_UPLOAD_HTTP_TIMEOUT")
+	defer os.Unsetenv("SD_CACHE_STRATEGY")
+	defer os.Unsetenv("SD_STORE_CLI_UPLOAD_HTTP_TIMEOUT")
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	// flag > env > file > default
+	testCases := []struct {
+		name, value, source string
+	}{
+		{"scope", "pipeline", "file " + path},
+		{"cache-strategy", "s3", "env SD_CACHE_STRATEGY"},
+		{"upload-timeout", "60", "file " + path},
+		{"download-timeout", "300", "default"},
+		{"type", "artifact", "flag --type"},
+	}
+	values := config.withFlags(func(flag string) (string, bool) {
+		return "artifact", flag == "type"
+	})
+	for _, tc := range testCases {
+		for _, value := range values {
+			if value.Name == tc.name && (value.Value != tc.value || value.Source != tc.source) {
+				t.Errorf("Expected %s to be %q from %s, got %q from %s", tc.name, tc.value, tc.source, value.Value, value.Source)
+			}
+		}
+	}
+
+	maxRetries := MAX_RETRIES
+	defer func() { MAX_RETRIES, ConfigExcludes = maxRetries, nil }()
+	if err = config.apply(); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if os.Getenv("SD_STORE_CLI_UPLOAD_HTTP_TIMEOUT") != "60" || CacheStrategy != "s3" || MAX_RETRIES != 2 {
+		t.Errorf("Expected the config to be applied, got timeout %q, strategy %q, retries %d", os.Getenv("SD_STORE_CLI_UPLOAD_HTTP_TIMEOUT"), CacheStrategy, MAX_RETRIES)
+	}
+	if patterns, _ := excludePatterns("tmp/"); strings.Join(patterns, ",") != "*.log,tmp/" {
+		t.Errorf("Expected the excludes of the config file first, got %v", patterns)
+	}
+	CacheStrategy = ""
+
+	_ = os.WriteFile(path, []byte("compression-level: 20\n"), 0644)
+	if config, err = loadConfig(path); err == nil {
+		err = config.apply()
+	}
+	sdstore.ZstdLevel = 0
+	if err == nil {
+		t.Errorf("Expected an error for an invalid compression level")
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home")
+	work := filepath.Join(dir, "work")
+	_ = os.MkdirAll(home, 0755)
+	_ = os.MkdirAll(work, 0755)
+
+	wd, _ := os.Getwd()
+	_ = os.Chdir(work)
+	defer os.Chdir(wd)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	if path := findConfigFile(); path != "" {
+		t.Errorf("Expected no config file, got %s", path)
+	}
+
+	_ = os.WriteFile(filepath.Join(home, ConfigFile), []byte("scope: event\n"), 0644)
+	if path := findConfigFile(); path != filepath.Join(home, ConfigFile) {
+		t.Errorf("Expected the config file of $HOME, got %s", path)
+	}
+
+	_ = os.WriteFile(filepath.Join(work, ConfigFile), []byte("scope: job\n"), 0644)
+	if path := findConfigFile(); path != filepath.Join(work, ConfigFile) {
+		t.Errorf("Expected the config file of the current directory, got %s", path)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	values := []ConfigValue{{Name: "scope", Value: "event", Source: "default"}}
+
+	var buf bytes.Buffer
+	if err := printConfig(&buf, values, "text"); err != nil || !strings.Contains(buf.String(), "scope") {
+		t.Errorf("Unexpected text output %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if err := printConfig(&buf, values, "json"); err != nil || !strings.Contains(buf.String(), `"source": "default"`) {
+		t.Errorf("Unexpected json output %q, %v", buf.String(), err)
+	}
+	if err := printConfig(&buf, values, "yaml"); err == nil {
+		t.Errorf("Expected an error for an invalid format")
+	}
+}
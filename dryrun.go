@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/screwdriver-cd/store-cli/sdstore"
+)
+
+// DryRun is what get, set or remove would do, printed with --dry-run
+type DryRun struct {
+	Operation string `json:"operation"`
+	Type      string `json:"type"`
+	Scope     string `json:"scope"`
+	Key       string `json:"key"`
+	// Skipped is true when the cache is skipped for Pull Requests
+	Skipped bool `json:"skipped"`
+	sdstore.Plan
+}
+
+// dryRun resolves what the operation get, set or remove would do with the item key, without changing anything.
+// A get tries the candidates of the cache in turn, as get does, and reports the first one found. bundle is true when key
+// lists the paths of several arguments, bundled in one archive
+func dryRun(operation, storeType, scope, key string, bundle bool, timeout int, restoreKeys []string, keyFiles, buildID, name, exclude string) (*DryRun, error) {
+	dr := &DryRun{Operation: operation, Type: storeType, Scope: scope, Key: key}
+	dr.Skipped = skipCache(storeType, scope, operation)
+
+	if storeType == "cache" {
+		backend, err := newBackend(timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		switch operation {
+		case "get":
+			candidates, err := cacheCandidates(scope, key, restoreKeys, keyFiles)
+			if err != nil {
+				return nil, err
+			}
+			for i, candidate := range candidates {
+				plan, err := backend.Plan(operation, candidate.Scope, candidate.Key, candidate.Path, sdstore.SetOptions{})
+				if err != nil {
+					return nil, err
+				}
+				if i == 0 || plan.Exists {
+					dr.Scope, dr.Key, dr.Plan = candidate.Scope, candidate.Key, *plan
+				}
+				if plan.Exists {
+					break
+				}
+			}
+			return dr, nil
+		case "set":
+			path, cacheKey, err := sdstore.ResolveKey(key, keyFiles)
+			if err != nil {
+				return nil, err
+			}
+			excludes, err := excludePatterns(exclude)
+			if err != nil {
+				return nil, err
+			}
+			options := sdstore.SetOptions{Exclude: excludes, Paths: bundlePaths(path, bundle)}
+			plan, err := backend.Plan(operation, scope, cacheKey, path, options)
+			if err != nil {
+				return nil, err
+			}
+			dr.Key, dr.Plan = cacheKey, *plan
+			return dr, nil
+		default:
+			_, cacheKey, err := sdstore.ResolveKey(key, keyFiles)
+			if err != nil {
+				return nil, err
+			}
+			plan, err := backend.Plan(operation, scope, cacheKey, "", sdstore.SetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			dr.Key, dr.Plan = cacheKey, *plan
+			return dr, nil
+		}
+	}
+
+	filePath := ""
+	if operation == "set" {
+		filePath = key
+		if name != "" {
+			dr.Key = name
+		}
+	}
+	var (
+		fullURL *url.URL
+		err     error
+	)
+	if buildID != "" {
+		fullURL, err = makeBuildArtifactURL(buildID, dr.Key)
+	} else {
+		fullURL, err = makeURL(storeType, scope, dr.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := excludePatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	store := sdstore.NewStore(os.Getenv("SD_TOKEN"), MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
+	plan, err := sdstore.PlanArtifact(store, fullURL, filePath, excludes)
+	if err != nil {
+		return nil, err
+	}
+	dr.Plan = *plan
+
+	return dr, nil
+}
+
+// printDryRun writes what the command would do to w as a table (text) or as json
+func printDryRun(w io.Writer, dr *DryRun, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(dr)
+	case "text", "":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		_, _ = fmt.Fprintf(tw, "OPERATION\t%s\n", dr.Operation)
+		_, _ = fmt.Fprintf(tw, "TYPE\t%s\n", dr.Type)
+		_, _ = fmt.Fprintf(tw, "SCOPE\t%s\n", dr.Scope)
+		_, _ = fmt.Fprintf(tw, "KEY\t%s\n", dr.Key)
+		_, _ = fmt.Fprintf(tw, "LOCATION\t%s\n", dr.Location)
+		_, _ = fmt.Fprintf(tw, "SKIPPED\t%v\n", dr.Skipped)
+		_, _ = fmt.Fprintf(tw, "EXISTS\t%v\n", dr.Exists)
+		if dr.Operation == "set" {
+			_, _ = fmt.Fprintf(tw, "FILES\t%d\n", dr.Files)
+			_, _ = fmt.Fprintf(tw, "SIZE\t%d\n", dr.Size)
+			_, _ = fmt.Fprintf(tw, "UNCHANGED\t%v\n", dr.Unchanged)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("invalid format %q, expected text or json", format)
+	}
+}
+
+// dryRunExit prints what the command would do to stdout, as json when stdout is not nil, then exits
+func dryRunExit(stdout *os.File, dr *DryRun, err error) {
+	if err != nil {
+		failureExit(err)
+	}
+	w, format := os.Stdout, "text"
+	if stdout != nil {
+		w, format = stdout, "json"
+	}
+	if err = printDryRun(w, dr, format); err != nil {
+		failureExit(err)
+	}
+	successExit()
+}
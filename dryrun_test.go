@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRunWithFakeStore(t *testing.T) {
+	server, dir := newFakeStore(t)
+
+	cache := filepath.Join(dir, "cache")
+	_ = os.MkdirAll(cache, 0755)
+	_ = os.WriteFile(filepath.Join(cache, "file"), []byte("cached"), 0644)
+
+	dr, err := dryRun("set", "cache", "event", cache, false, 10, nil, "", "", "", "")
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if dr.Exists || dr.Unchanged || dr.Files != 1 || dr.Size != 6 || !strings.HasPrefix(dr.Location, server.StoreURL()+"caches/events/499/") {
+		t.Errorf("Unexpected dry run of a new cache %+v", dr)
+	}
+	if paths := server.Paths(); len(paths) != 0 {
+		t.Errorf("Expected nothing to be uploaded, got %v", paths)
+	}
+
+	if err := set("cache", "event", cache, false, 10, "", 0, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if dr, err = dryRun("set", "cache", "event", cache, false, 10, nil, "", "", "", ""); err != nil || !dr.Exists || !dr.Unchanged {
+		t.Errorf("Expected an unchanged cache, got %+v, %v", dr, err)
+	}
+	_ = os.WriteFile(filepath.Join(cache, "file"), []byte("changed"), 0644)
+	if dr, err = dryRun("set", "cache", "event", cache, false, 10, nil, "", "", "", ""); err != nil || dr.Unchanged {
+		t.Errorf("Expected a changed cache, got %+v, %v", dr, err)
+	}
+
+	// get reports the first candidate found
+	if dr, err = dryRun("get", "cache", "job,event", cache, false, 10, nil, "", "", "", ""); err != nil || !dr.Exists || dr.Scope != "event" {
+		t.Errorf("Expected the event cache, got %+v, %v", dr, err)
+	}
+
+	t.Setenv("SD_PULL_REQUEST", "1")
+	if dr, err = dryRun("remove", "cache", "pipeline", cache, false, 10, nil, "", "", "", ""); err != nil || !dr.Skipped || dr.Exists {
+		t.Errorf("Expected the pipeline cache to be skipped for a Pull Request, got %+v, %v", dr, err)
+	}
+
+	if dr, err = dryRun("set", "artifact", "", filepath.Join(cache, "file"), false, 10, nil, "", "", "report.txt", ""); err != nil || dr.Key != "report.txt" || dr.Location != server.StoreURL()+"builds/10038/ARTIFACTS/report.txt" || dr.Files != 1 || dr.Size != 7 {
+		t.Errorf("Unexpected dry run of an artifact %+v, %v", dr, err)
+	}
+}
+
+func TestPrintDryRun(t *testing.T) {
+	dr := &DryRun{Operation: "set", Type: "cache", Scope: "event", Key: "node_modules"}
+	dr.Location, dr.Files = "/cache/node_modules", 3
+
+	var buf bytes.Buffer
+	if err := printDryRun(&buf, dr, "text"); err != nil || !strings.Contains(buf.String(), "/cache/node_modules") || !strings.Contains(buf.String(), "UNCHANGED") {
+		t.Errorf("Unexpected text output %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if err := printDryRun(&buf, dr, "json"); err != nil || !strings.Contains(buf.String(), `"location": "/cache/node_modules"`) || !strings.Contains(buf.String(), `"files": 3`) {
+		t.Errorf("Unexpected json output %q, %v", buf.String(), err)
+	}
+	if err := printDryRun(&buf, dr, "yaml"); err == nil {
+		t.Errorf("Expected an error for an invalid format")
+	}
+}
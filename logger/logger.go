@@ -27,11 +27,20 @@ func NewProductionEncoderConfig() zapcore.EncoderConfig {
 }
 
 func init() {
+	build("stdout")
+}
+
+// UseStderr writes the logs to stderr, keeping stdout for the output of the command
+func UseStderr() {
+	build("stderr")
+}
+
+func build(outputPath string) {
 	cfg := zap.NewProductionConfig()
 	cfg.Encoding = "json"
 	cfg.Level = zap.NewAtomicLevelAt(Loglevel)
 	cfg.InitialFields = map[string]interface{}{"app": "store-cli"}
-	cfg.OutputPaths = []string{"stdout"}
+	cfg.OutputPaths = []string{outputPath}
 	cfg.ErrorOutputPaths = []string{"stderr"}
 	cfg.DisableStacktrace = true
 	cfg.DisableCaller = true
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/screwdriver-cd/store-cli/logger"
+	"github.com/screwdriver-cd/store-cli/sdstore"
+)
+
+// Result is the outcome of a get, set or remove, printed as json with --format json
+type Result struct {
+	Operation        string  `json:"operation"`
+	Type             string  `json:"type"`
+	Scope            string  `json:"scope"`
+	Key              string  `json:"key"`
+	URL              string  `json:"url,omitempty"`
+	Path             string  `json:"path,omitempty"`
+	Hit              *bool   `json:"hit,omitempty"`
+	Bytes            int64   `json:"bytes"`
+	CompressionRatio float64 `json:"compressionRatio"`
+	Duration         float64 `json:"duration"` // seconds
+	Error            string  `json:"error,omitempty"`
+
+	started time.Time
+	stats   sdstore.Stats
+}
+
+// report is the result of the running command, which get, set and remove complete with the key, scope,
+// url and path they resolve
+var report = &Result{}
+
+// newResult starts the result of operation on the item key
+func newResult(operation, storeType, scope, key string) *Result {
+	return &Result{Operation: operation, Type: storeType, Scope: scope, Key: key, started: time.Now(), stats: sdstore.GetStats()}
+}
+
+// finish completes the result with the stats of the transfer since the result started and the error of the operation, if any
+func (r *Result) finish(err error) {
+	total := sdstore.GetStats()
+	stats := sdstore.Stats{
+		Uploaded:    total.Uploaded - r.stats.Uploaded,
+		Downloaded:  total.Downloaded - r.stats.Downloaded,
+		ArchiveSize: total.ArchiveSize - r.stats.ArchiveSize,
+		ContentSize: total.ContentSize - r.stats.ContentSize,
+	}
+	switch r.Operation {
+	case "get":
+		r.Bytes = stats.Downloaded
+		if r.Hit == nil {
+			r.found(err)
+		}
+	case "set":
+		r.Bytes = stats.Uploaded
+	}
+	r.CompressionRatio = stats.CompressionRatio()
+	r.Duration = time.Since(r.started).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// found records whether get found the item from its error, before --fail-on-miss and --ignore-errors apply.
+// An empty item is a hit too
+func (r *Result) found(err error) {
+	hit := err == nil
+	r.Hit = &hit
+}
+
+// getFormat returns the format of the result of get and its output. --output json or text chooses the format
+// of the result of a cache, which is restored at its path, while it is the path an artifact or log is written to
+func getFormat(storeType, format, output string) (string, string) {
+	if value := strings.ToLower(output); storeType == "cache" && format == "" && (value == "json" || value == "text") {
+		return value, ""
+	}
+
+	return format, output
+}
+
+// printResult writes the result to w as json
+func printResult(w io.Writer, r *Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// jsonOutput checks format, text or json. With json, the logs and any other output go to stderr so that stdout
+// only gets the result, and the original stdout is returned to print it. It returns nil with text
+func jsonOutput(format string) (*os.File, error) {
+	switch format {
+	case "text", "":
+		return nil, nil
+	case "json":
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		logger.UseStderr()
+		return stdout, nil
+	default:
+		return nil, fmt.Errorf("invalid format %q, expected text or json", format)
+	}
+}
+
+// resultExit prints the result of the command to stdout, when it is not nil, then exits with the status of err:
+// 0 without error, 2 when the item is missing and 1 on any other error
+func resultExit(stdout *os.File, err error) {
+	if stdout != nil {
+		report.finish(err)
+		if printErr := printResult(stdout, report); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	if errors.Is(err, sdstore.ErrNotFound) {
+		missExit(err)
+	}
+	if err != nil {
+		failureExit(err)
+	}
+	successExit()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResultWithFakeStore(t *testing.T) {
+	server, dir := newFakeStore(t)
+	defer func() { report = &Result{} }()
+
+	artifact := filepath.Join(dir, "report.json")
+	_ = os.WriteFile(artifact, []byte(`{"passed":true}`), 0644)
+
+	report = newResult("set", "artifact", "", artifact)
+	err := set("artifact", "", artifact, false, 10, "", 0, "", "report.json", "")
+	report.finish(err)
+	if err != nil || report.Bytes != 15 || report.Key != "report.json" || report.URL != server.StoreURL()+"builds/10038/ARTIFACTS/report.json" {
+		t.Errorf("Unexpected result of set %+v, %v", report, err)
+	}
+	if report.Hit != nil || report.Path != artifact {
+		t.Errorf("Expected the path and no hit in the result of set, got %+v", report)
+	}
+
+	output := filepath.Join(dir, "downloaded.json")
+	report = newResult("get", "artifact", "", "report.json")
+	err = get("artifact", "", "report.json", false, 10, false, nil, "", "", output)
+	report.finish(err)
+	if err != nil || report.Hit == nil || !*report.Hit || report.Bytes != 15 || report.Path != output {
+		t.Errorf("Unexpected result of get %+v, %v", report, err)
+	}
+
+	report = newResult("get", "artifact", "", "missing.json")
+	err = get("artifact", "", "missing.json", false, 10, false, nil, "", "", output)
+	report.finish(err)
+	if err == nil || report.Hit == nil || *report.Hit || report.Bytes != 0 || report.Error == "" {
+		t.Errorf("Expected a miss in the result of get, got %+v, %v", report, err)
+	}
+
+	// an empty artifact is a hit
+	server.Put("builds/10038/ARTIFACTS/empty.txt", nil)
+	report = newResult("get", "artifact", "", "empty.txt")
+	err = get("artifact", "", "empty.txt", false, 10, false, nil, "", "", output)
+	report.finish(err)
+	if err != nil || report.Hit == nil || !*report.Hit || report.Bytes != 0 {
+		t.Errorf("Expected a hit in the result of get, got %+v, %v", report, err)
+	}
+}
+
+func TestCacheResultWithFakeStore(t *testing.T) {
+	server, dir := newFakeStore(t)
+	defer func() { report = &Result{} }()
+
+	cache := filepath.Join(dir, "cache")
+	_ = os.MkdirAll(cache, 0755)
+	_ = os.WriteFile(filepath.Join(cache, "file"), []byte("cached"), 0644)
+
+	// the bytes are those of the archive, not of the md5 json stored with it
+	report = newResult("set", "cache", "event", cache)
+	err := set("cache", "event", cache, false, 10, "", 0, "", "", "")
+	report.finish(err)
+	var archive int64
+	for _, path := range server.Paths() {
+		if strings.HasSuffix(path, ".tar.zst") {
+			item, _ := server.Get(path)
+			archive = int64(len(item.Body))
+		}
+	}
+	location := server.StoreURL() + "caches/events/499/" + url.PathEscape(cache)
+	if err != nil || archive == 0 || report.Bytes != archive || report.URL != location || report.Path != cache {
+		t.Errorf("Unexpected result of set %+v with an archive of %d B, %v", report, archive, err)
+	}
+
+	_ = os.RemoveAll(cache)
+	report = newResult("get", "cache", "event", cache)
+	err = get("cache", "event", cache, false, 10, false, nil, "", "", "")
+	report.finish(err)
+	if err != nil || report.Hit == nil || !*report.Hit || report.Bytes != archive || report.URL != location || report.Path != cache {
+		t.Errorf("Unexpected result of get %+v, %v", report, err)
+	}
+
+	report = newResult("remove", "cache", "event", cache)
+	err = remove("cache", "event", cache, 10, "")
+	report.finish(err)
+	if err != nil || report.URL != location || report.Path != cache {
+		t.Errorf("Unexpected result of remove %+v, %v", report, err)
+	}
+
+	// a missing cache is not an error, but it is a miss
+	report = newResult("get", "cache", "event", cache)
+	err = get("cache", "event", cache, false, 10, false, nil, "", "", "")
+	report.found(err)
+	report.finish(getPolicy("cache", err, false, false))
+	if report.Hit == nil || *report.Hit || report.Error != "" {
+		t.Errorf("Expected a miss without error in the result of get, got %+v, %v", report, err)
+	}
+}
+
+func TestGetFormat(t *testing.T) {
+	tests := []struct {
+		storeType, format, output string
+		wantFormat, wantOutput    string
+	}{
+		{"cache", "", "json", "json", ""},
+		{"cache", "", "TEXT", "text", ""},
+		{"cache", "json", "", "json", ""},
+		// the output of an artifact is where it is written
+		{"artifact", "", "json", "", "json"},
+		{"artifact", "json", "report.json", "json", "report.json"},
+	}
+
+	for _, tt := range tests {
+		format, output := getFormat(tt.storeType, tt.format, tt.output)
+		if format != tt.wantFormat || output != tt.wantOutput {
+			t.Errorf("getFormat(%q, %q, %q) = %q, %q, want %q, %q", tt.storeType, tt.format, tt.output, format, output, tt.wantFormat, tt.wantOutput)
+		}
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	r := newResult("get", "cache", "event", "node_modules")
+	r.finish(errors.New("failed"))
+
+	var buf bytes.Buffer
+	if err := printResult(&buf, r); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	var printed map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &printed); err != nil {
+		t.Fatalf("Expected a json result, got %q: %v", buf.String(), err)
+	}
+	for _, field := range []string{"operation", "type", "scope", "key", "hit", "bytes", "compressionRatio", "duration", "error"} {
+		if _, ok := printed[field]; !ok {
+			t.Errorf("Expected %s in the result, got %q", field, buf.String())
+		}
+	}
+	if printed["hit"] != false || printed["error"] != "failed" {
+		t.Errorf("Expected a failed get to be a miss, got %q", buf.String())
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	if stdout, err := jsonOutput("text"); stdout != nil || err != nil {
+		t.Errorf("Expected no redirection of stdout for text, got %v, %v", stdout, err)
+	}
+	if _, err := jsonOutput("yaml"); err == nil {
+		t.Errorf("Expected an error for an invalid format")
+	}
+
+	stdout := os.Stdout
+	defer func() { os.Stdout = stdout }()
+	if saved, err := jsonOutput("json"); saved != stdout || os.Stdout != os.Stderr || err != nil {
+		t.Errorf("Expected stdout to be redirected to stderr for json, got %v", err)
+	}
+}
@@ -0,0 +1,483 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// names of the registered backends, selected with SD_CACHE_STRATEGY
+const (
+	RemoteBackend = "remote"
+	DiskBackend   = "disk"
+	S3Backend     = "s3"
+)
+
+// ErrNotSupported is returned by a backend for an operation it does not support
+var ErrNotSupported = errors.New("not supported by this backend")
+
+// Backend gets, sets and removes the caches of a scope (event, job or pipeline). A cache of the path src
+// is stored under key, which is src itself unless the key is derived from a template (see ResolveKey)
+type Backend interface {
+	Get(scope, key, src string) error
+	Set(scope, key, src string, options SetOptions) error
+	Remove(scope, key string) error
+	List(scope string) ([]ItemInfo, error)
+	Stat(scope, key string) (*ItemInfo, error)
+	Verify(scope, key, src string) error
+	// Plan resolves what the command get, set or remove would do with the cache of src stored under key
+	Plan(command, scope, key, src string, options SetOptions) (*Plan, error)
+	// Location returns where the cache stored under key is kept, a url or a path
+	Location(scope, key string) (string, error)
+}
+
+// SetOptions holds the optional settings of a cache being set
+type SetOptions struct {
+	// TTL is how long the cache stays valid, 0 for no expiry. Get and Stat treat an expired cache as missing
+	TTL time.Duration
+	// Paths are bundled in one archive instead of src, see IsBundle
+	Paths []string
+	// Exclude are gitignore-style patterns of the files and directories left out of the cache
+	Exclude []string
+}
+
+// BackendConfig holds the settings a backend is created with
+type BackendConfig struct {
+	Token            string
+	StoreURL         string
+	MaxRetries       int
+	HTTPTimeout      int
+	RetryWaitMin     int
+	RetryWaitMax     int
+	UseExpectHeader  bool
+	CacheMaxSizeInMB int64
+	// ScopeID returns the id of the event, job or pipeline a scope refers to
+	ScopeID func(scope string) string
+}
+
+// BackendFactory creates a backend
+type BackendFactory func(config BackendConfig) (Backend, error)
+
+var backends = map[string]BackendFactory{}
+
+func init() {
+	RegisterBackend(RemoteBackend, newRemoteBackend)
+	RegisterBackend(DiskBackend, newDiskBackend)
+	RegisterBackend(S3Backend, newS3Backend)
+}
+
+// RegisterBackend registers a backend under name, replacing any backend registered under the same name
+func RegisterBackend(name string, factory BackendFactory) {
+	backends[strings.ToLower(name)] = factory
+}
+
+// NewBackend creates the backend registered under name. The remote store is used when no backend is registered under name
+func NewBackend(name string, config BackendConfig) (Backend, error) {
+	factory, ok := backends[strings.ToLower(name)]
+	if !ok {
+		factory = backends[RemoteBackend]
+	}
+
+	return factory(config)
+}
+
+// storeBackend keeps the caches in an SDStore, as <src>.tar.zst archives (or legacy <src>.zip archives)
+// along with the md5 of their files and, when they expire, their expiry in <src>_md5.json
+type storeBackend struct {
+	store   SDStore
+	baseURL string
+	config  BackendConfig
+}
+
+// md5Store is implemented by the SDStores which can download the md5 json of a cache
+type md5Store interface {
+	getMd5Json(url *url.URL) (map[string]string, error)
+}
+
+func newRemoteBackend(config BackendConfig) (Backend, error) {
+	store := NewStore(config.Token, config.MaxRetries, config.HTTPTimeout, config.RetryWaitMin, config.RetryWaitMax)
+
+	return &storeBackend{store: store, baseURL: config.StoreURL, config: config}, nil
+}
+
+func newS3Backend(config BackendConfig) (Backend, error) {
+	s3Config := getS3Config()
+	if s3Config.Bucket == "" {
+		// s3 is also the name Screwdriver gives to the Store API strategy, which keeps caches in S3
+		return newRemoteBackend(config)
+	}
+	if s3Config.AccessKeyID == "" || s3Config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("SD_S3_ACCESS_KEY_ID and SD_S3_SECRET_ACCESS_KEY must be set to use bucket %s", s3Config.Bucket)
+	}
+	store := NewS3Store(s3Config, config.MaxRetries, config.HTTPTimeout, config.RetryWaitMin, config.RetryWaitMax)
+
+	return &storeBackend{store: store, baseURL: s3Config.bucketURL(), config: config}, nil
+}
+
+// scopeURL returns the url under which the caches of a scope are stored
+func (b *storeBackend) scopeURL(scope string) (*url.URL, error) {
+	if scope != "event" && scope != "job" && scope != "pipeline" {
+		return nil, fmt.Errorf("invalid parameters")
+	}
+
+	var id string
+	if b.config.ScopeID != nil {
+		id = b.config.ScopeID(scope)
+	}
+
+	return url.Parse(fmt.Sprintf("%scaches/%ss/%s/", b.baseURL, scope, id))
+}
+
+// cacheURL returns the url of the cache stored under key, followed by suffix
+func (b *storeBackend) cacheURL(scope, key, suffix string) (*url.URL, error) {
+	key, err := normalizeSrc(key)
+	if err != nil {
+		return nil, err
+	}
+	scopeURL, err := b.scopeURL(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.Parse(scopeURL.String() + url.PathEscape(key+suffix))
+}
+
+// checkExpiry returns an error wrapping ErrNotFound when the cache stored under key expired
+func (b *storeBackend) checkExpiry(scope, key string) error {
+	store, ok := b.store.(md5Store)
+	if !ok {
+		return nil
+	}
+	u, err := b.cacheURL(scope, key, "_md5.json")
+	if err != nil {
+		return err
+	}
+
+	// a cache without md5 json, like a legacy zip archive, never expires
+	sums, err := store.getMd5Json(u)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	meta, err := md5Meta(sums)
+	if err != nil {
+		return err
+	}
+	if meta.Expired(time.Now()) {
+		return expiredError(key, meta)
+	}
+
+	return nil
+}
+
+// Get downloads the cache stored under key and extracts it next to src, unless it expired
+func (b *storeBackend) Get(scope, key, src string) error {
+	u, err := b.cacheURL(scope, key, "")
+	if err != nil {
+		return err
+	}
+	if err = b.checkExpiry(scope, key); err != nil {
+		return err
+	}
+	filePath, err := normalizeSrc(src)
+	if err != nil {
+		return err
+	}
+
+	return b.store.DownloadTo(u, filePath, true)
+}
+
+// Set compresses and uploads src under key along with when it expires, unless its contents and expiry are unchanged
+func (b *storeBackend) Set(scope, key, src string, options SetOptions) error {
+	u, err := b.cacheURL(scope, key, "")
+	if err != nil {
+		return err
+	}
+
+	return b.store.UploadWithOptions(u, src, UploadOptions{Compress: true, UseExpectHeader: b.config.UseExpectHeader, Paths: options.Paths, Exclude: options.Exclude, TTL: options.TTL})
+}
+
+// Remove removes the md5 json of the cache stored under key, then both its archive and its legacy zip archive
+func (b *storeBackend) Remove(scope, key string) error {
+	md5URL, err := b.cacheURL(scope, key, "_md5.json")
+	if err != nil {
+		return err
+	}
+
+	err = b.store.Remove(md5URL)
+	if err != nil {
+		return fmt.Errorf("failed to remove file from %s: %s", md5URL.String(), err)
+	}
+
+	var removed bool
+	for _, format := range []string{CompressFormatTarZst, CompressFormatZip} {
+		archiveURL, err := b.cacheURL(scope, key, format)
+		if err != nil {
+			return err
+		}
+
+		err = b.store.Remove(archiveURL)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove file from %s: %s", archiveURL.String(), err)
+		}
+		removed = true
+	}
+
+	if !removed {
+		return fmt.Errorf("failed to remove archive of %s: %w", key, ErrNotFound)
+	}
+
+	return nil
+}
+
+// List returns the caches of a scope
+func (b *storeBackend) List(scope string) ([]ItemInfo, error) {
+	u, err := b.scopeURL(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := b.store.List(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return CacheItems(items), nil
+}
+
+// Stat checks the archive of the cache stored under key, then its legacy zip archive. An expired cache is missing
+func (b *storeBackend) Stat(scope, key string) (*ItemInfo, error) {
+	var (
+		info *ItemInfo
+		err  error
+	)
+	for _, format := range []string{CompressFormatTarZst, CompressFormatZip} {
+		var archiveURL *url.URL
+		archiveURL, err = b.cacheURL(scope, key, format)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err = b.store.Stat(archiveURL)
+		if !errors.Is(err, ErrNotFound) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = b.checkExpiry(scope, key); err != nil {
+		return nil, err
+	}
+	info.Key = key
+
+	return info, nil
+}
+
+// Verify checks the files restored at src against the md5 json of the cache stored under key
+func (b *storeBackend) Verify(scope, key, src string) error {
+	u, err := b.cacheURL(scope, key, "")
+	if err != nil {
+		return err
+	}
+
+	return b.store.Verify(u, src)
+}
+
+// Location returns the url of the cache stored under key
+func (b *storeBackend) Location(scope, key string) (string, error) {
+	u, err := b.cacheURL(scope, key, "")
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+// Plan resolves the url of the cache stored under key and whether it exists. For set, it also walks src
+// and compares its md5 json with the stored one, as the upload would
+func (b *storeBackend) Plan(command, scope, key, src string, options SetOptions) (*Plan, error) {
+	location, err := b.Location(scope, key)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Location: location}
+	_, err = b.Stat(scope, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	plan.Exists = err == nil
+	if command != "set" {
+		return plan, nil
+	}
+
+	ex, err := newExcludes(options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	var newMd5 map[string]string
+	if len(options.Paths) > 0 {
+		entries, err := expandBundle(options.Paths)
+		if err != nil {
+			return nil, err
+		}
+		fInfos, _, _, size := bundleMetadata(entries, ex)
+		plan.Files, plan.Size = countFiles(fInfos), size
+		if newMd5, err = bundleMD5(options.Paths, entries, ex); err != nil {
+			return nil, err
+		}
+	} else {
+		fInfos, _, size := getMetadataInfo(src, ex)
+		plan.Files, plan.Size = countFiles(fInfos), size
+		if newMd5, err = cacheMD5(src, ex); err != nil {
+			return nil, err
+		}
+	}
+
+	// the same comparison as the upload, which skips the archive when the files are the same
+	if store, ok := b.store.(md5Store); ok {
+		md5URL, err := b.cacheURL(scope, key, "_md5.json")
+		if err != nil {
+			return nil, err
+		}
+		oldMd5, err := store.getMd5Json(md5URL)
+		plan.Unchanged = err == nil && sameFiles(oldMd5, newMd5)
+	}
+
+	return plan, nil
+}
+
+// diskBackend keeps the caches in the shared file server mounted at SD_<SCOPE>_CACHE_DIR
+type diskBackend struct {
+	cacheMaxSizeInMB int64
+}
+
+func newDiskBackend(config BackendConfig) (Backend, error) {
+	return &diskBackend{cacheMaxSizeInMB: config.CacheMaxSizeInMB}, nil
+}
+
+func (b *diskBackend) Get(scope, key, src string) error {
+	return Cache2DiskWithOptions("get", scope, src, CacheOptions{MaxSizeInMB: b.cacheMaxSizeInMB, Key: key, Strict: true})
+}
+
+func (b *diskBackend) Set(scope, key, src string, options SetOptions) error {
+	return Cache2DiskWithOptions("set", scope, src, CacheOptions{MaxSizeInMB: b.cacheMaxSizeInMB, Key: key, TTL: options.TTL, Paths: options.Paths, Exclude: options.Exclude})
+}
+
+func (b *diskBackend) Remove(scope, key string) error {
+	return Cache2Disk("remove", scope, key, b.cacheMaxSizeInMB)
+}
+
+func (b *diskBackend) List(scope string) ([]ItemInfo, error) {
+	return ListCache(scope)
+}
+
+func (b *diskBackend) Stat(scope, key string) (*ItemInfo, error) {
+	return StatCache(scope, key)
+}
+
+// Verify is not supported, the disk cache checks the md5 of its archives instead
+func (b *diskBackend) Verify(scope, key, src string) error {
+	return ErrNotSupported
+}
+
+// Location returns the path of the cache stored under key in the cache directory of scope
+func (b *diskBackend) Location(scope, key string) (string, error) {
+	return diskCachePath(scope, key)
+}
+
+// Plan resolves the path of the cache stored under key and whether it exists. For set, it also walks src
+// and compares the md5 of its files with the stored one, as setCache would
+func (b *diskBackend) Plan(command, scope, key, src string, options SetOptions) (*Plan, error) {
+	cache, err := b.Location(scope, key)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Location: cache}
+	_, err = StatCache(scope, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	plan.Exists = err == nil
+	if command != "set" {
+		return plan, nil
+	}
+
+	ex, err := newExcludes(options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		fInfos []*FileInfo
+		newMd5 string
+	)
+	// a directory is cached inside itself, a file or a bundle next to itself
+	destPath, destBase := filepath.Dir(cache), filepath.Base(cache)
+	if len(options.Paths) > 0 {
+		entries, err := expandBundle(options.Paths)
+		if err != nil {
+			return nil, err
+		}
+		fInfos, _, newMd5, plan.Size = bundleMetadata(entries, ex)
+	} else {
+		if src, err = normalizeSrc(src); err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(src)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			destPath = cache
+		}
+		fInfos, newMd5, plan.Size = getMetadataInfo(src, ex)
+	}
+	plan.Files = countFiles(fInfos)
+	plan.Unchanged = compareMd5(newMd5, destPath, destBase)
+
+	return plan, nil
+}
+
+// Candidate is a cache to restore at Path, stored under Key in a scope
+type Candidate struct {
+	Scope string
+	Key   string
+	Path  string
+}
+
+// GetFirst restores the first of candidates found in backend and returns it. Candidates are checked with Stat,
+// and a candidate which cannot be checked is skipped. When none is found, the last error is returned, wrapping
+// ErrNotFound if every candidate is missing. A single candidate is restored without being checked first
+func GetFirst(backend Backend, candidates []Candidate) (*Candidate, error) {
+	if len(candidates) == 1 {
+		return &candidates[0], backend.Get(candidates[0].Scope, candidates[0].Key, candidates[0].Path)
+	}
+
+	err := fmt.Errorf("no cache candidates: %w", ErrNotFound)
+	for i, candidate := range candidates {
+		_, err = backend.Stat(candidate.Scope, candidate.Key)
+		if errors.Is(err, ErrNotFound) {
+			log.Printf("Cache miss: %s in %s scope", candidate.Key, candidate.Scope)
+			continue
+		}
+		if err != nil {
+			log.Printf("Unable to check %s in %s scope, skipping: %v", candidate.Key, candidate.Scope, err)
+			continue
+		}
+
+		log.Printf("Cache hit: %s in %s scope", candidate.Key, candidate.Scope)
+		return &candidates[i], backend.Get(candidate.Scope, candidate.Key, candidate.Path)
+	}
+
+	return nil, fmt.Errorf("no cache found in %d candidates: %w", len(candidates), err)
+}
@@ -0,0 +1,235 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/screwdriver-cd/store-cli/sdstore/sdstoretest"
+)
+
+func TestNewBackend(t *testing.T) {
+	config := BackendConfig{StoreURL: "http://store.screwdriver.cd/v1/"}
+
+	tests := []struct {
+		name   string
+		bucket string
+		want   string
+	}{
+		{"", "", "*sdstore.storeBackend"},
+		{"remote", "", "*sdstore.storeBackend"},
+		{"unknown", "", "*sdstore.storeBackend"},
+		{"DISK", "", "*sdstore.diskBackend"},
+		{"s3", "", "*sdstore.storeBackend"},
+		{"s3", "caches", "*sdstore.storeBackend"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.bucket, func(t *testing.T) {
+			os.Setenv("SD_S3_BUCKET", tt.bucket)
+			os.Setenv("SD_S3_ACCESS_KEY_ID", "fakeaccesskey")
+			os.Setenv("SD_S3_SECRET_ACCESS_KEY", "fakesecretkey")
+			defer os.Unsetenv("SD_S3_BUCKET")
+			defer os.Unsetenv("SD_S3_ACCESS_KEY_ID")
+			defer os.Unsetenv("SD_S3_SECRET_ACCESS_KEY")
+
+			backend, err := NewBackend(tt.name, config)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := fmt.Sprintf("%T", backend); got != tt.want {
+				t.Errorf("NewBackend(%q) = %s, want %s", tt.name, got, tt.want)
+			}
+
+			if b, ok := backend.(*storeBackend); ok {
+				_, isS3 := b.store.(*s3Store)
+				if isS3 != (tt.bucket != "") {
+					t.Errorf("NewBackend(%q) with bucket %q uses store %T", tt.name, tt.bucket, b.store)
+				}
+			}
+		})
+	}
+}
+
+func TestNewS3BackendMissingCredentials(t *testing.T) {
+	os.Setenv("SD_S3_BUCKET", "caches")
+	defer os.Unsetenv("SD_S3_BUCKET")
+
+	if _, err := NewBackend(S3Backend, BackendConfig{}); err == nil {
+		t.Error("Expected an error without credentials")
+	}
+}
+
+type fakeBackend struct {
+	Backend
+}
+
+func TestRegisterBackend(t *testing.T) {
+	RegisterBackend("fake", func(config BackendConfig) (Backend, error) {
+		return &fakeBackend{}, nil
+	})
+	defer delete(backends, "fake")
+
+	backend, err := NewBackend("fake", BackendConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := backend.(*fakeBackend); !ok {
+		t.Errorf("Expected the registered backend, got %T", backend)
+	}
+}
+
+// mapBackend is a backend keeping the scope and key of the caches set
+type mapBackend struct {
+	Backend
+	caches   map[Candidate]bool
+	restored []Candidate
+}
+
+func (b *mapBackend) Get(scope, key, src string) error {
+	b.restored = append(b.restored, Candidate{scope, key, src})
+	return nil
+}
+
+func (b *mapBackend) Stat(scope, src string) (*ItemInfo, error) {
+	if scope == "broken" {
+		return nil, fmt.Errorf("broken scope")
+	}
+	if !b.caches[Candidate{scope, src, src}] {
+		return nil, ErrNotFound
+	}
+	return &ItemInfo{Key: src}, nil
+}
+
+func TestGetFirst(t *testing.T) {
+	backend := &mapBackend{caches: map[Candidate]bool{{"pipeline", "node_modules", "node_modules"}: true, {"event", "vendor", "vendor"}: true}}
+
+	hit, err := GetFirst(backend, []Candidate{{"broken", "node_modules", "node_modules"}, {"event", "node_modules", "node_modules"}, {"pipeline", "node_modules", "node_modules"}, {"event", "vendor", "vendor"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *hit != (Candidate{"pipeline", "node_modules", "node_modules"}) || len(backend.restored) != 1 || backend.restored[0] != *hit {
+		t.Errorf("Expected the pipeline cache to be restored, got %v, restored %v", hit, backend.restored)
+	}
+
+	_, err = GetFirst(backend, []Candidate{{"event", "missing", "missing"}, {"job", "missing", "missing"}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// a single candidate is restored without being checked
+	hit, err = GetFirst(backend, []Candidate{{"job", "missing", "missing"}})
+	if err != nil || hit.Key != "missing" || len(backend.restored) != 2 {
+		t.Errorf("Expected a single candidate to be restored, got %v, %v", hit, err)
+	}
+}
+
+func TestDiskBackendGetMiss(t *testing.T) {
+	cacheDir := t.TempDir()
+	defer os.Setenv("SD_PIPELINE_CACHE_DIR", os.Getenv("SD_PIPELINE_CACHE_DIR"))
+	os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+
+	backend, _ := NewBackend(DiskBackend, BackendConfig{})
+	src := filepath.Join(t.TempDir(), "node_modules")
+
+	// a missing cache is reported like with the remote backend, unlike with Cache2Disk
+	if err := backend.Get("pipeline", src, src); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing cache, got %v", err)
+	}
+	if err := Cache2Disk("get", "pipeline", src, 0); err != nil {
+		t.Errorf("Expected Cache2Disk to ignore a missing cache, got %v", err)
+	}
+
+	_ = os.MkdirAll(src, 0777)
+	_ = os.WriteFile(filepath.Join(src, "module.js"), []byte("v1"), 0777)
+	if err := backend.Set("pipeline", src, src, SetOptions{}); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	_ = os.RemoveAll(src)
+	if err := backend.Get("pipeline", src, src); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+
+	metaPath := filepath.Join(cacheDir, src, "node_modules"+MetaExtension)
+	_ = os.WriteFile(metaPath, []byte(`{"expiresAt":"2020-01-01T00:00:00Z"}`), 0777)
+	if err := backend.Get("pipeline", src, src); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an expired cache, got %v", err)
+	}
+}
+
+func TestDiskBackendPlan(t *testing.T) {
+	cacheDir := t.TempDir()
+	defer os.Setenv("SD_PIPELINE_CACHE_DIR", os.Getenv("SD_PIPELINE_CACHE_DIR"))
+	os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+
+	backend, _ := NewBackend(DiskBackend, BackendConfig{})
+	src := filepath.Join(t.TempDir(), "node_modules")
+	_ = os.MkdirAll(src, 0777)
+	_ = os.WriteFile(filepath.Join(src, "module.js"), []byte("v1"), 0777)
+
+	plan, err := backend.Plan("set", "pipeline", src, src, SetOptions{})
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if plan.Location != filepath.Join(cacheDir, src) || plan.Exists || plan.Unchanged || plan.Files != 1 || plan.Size != 2 {
+		t.Errorf("Unexpected plan of a new cache %+v", plan)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, src)); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written, got %v", err)
+	}
+
+	if err = backend.Set("pipeline", src, src, SetOptions{}); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if plan, err = backend.Plan("set", "pipeline", src, src, SetOptions{}); err != nil || !plan.Exists || !plan.Unchanged {
+		t.Errorf("Expected an unchanged cache, got %+v, %v", plan, err)
+	}
+	if plan, err = backend.Plan("get", "pipeline", src+"@v2", src, SetOptions{}); err != nil || plan.Exists {
+		t.Errorf("Expected a missing cache, got %+v, %v", plan, err)
+	}
+}
+
+func TestGetCorruptCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	defer os.Setenv("SD_PIPELINE_CACHE_DIR", os.Getenv("SD_PIPELINE_CACHE_DIR"))
+	os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+
+	server := sdstoretest.NewServer("faketoken")
+	defer server.Close()
+	remote, _ := newRemoteBackend(BackendConfig{
+		Token:    "faketoken",
+		StoreURL: server.StoreURL(),
+		ScopeID:  func(scope string) string { return "1234" },
+	})
+	disk, _ := NewBackend(DiskBackend, BackendConfig{})
+
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	_ = os.Chdir(dir)
+	defer os.Chdir(wd)
+	src := filepath.Join(dir, "node_modules")
+	_ = os.MkdirAll(src, 0777)
+	_ = os.WriteFile(filepath.Join(src, "module.js"), []byte("v1"), 0777)
+
+	// a cache which cannot be extracted fails the get of both backends
+	for name, backend := range map[string]Backend{"remote": remote, "disk": disk} {
+		if err := backend.Set("pipeline", src, src, SetOptions{}); err != nil {
+			t.Fatalf("Expected nil error setting the %s cache, got %v", name, err)
+		}
+	}
+	for _, path := range server.Paths() {
+		if strings.HasSuffix(path, CompressFormatTarZst) {
+			server.Put(path, []byte("not an archive"))
+		}
+	}
+	_ = os.WriteFile(filepath.Join(cacheDir, src, "node_modules"+CompressFormatTarZst), []byte("not an archive"), 0777)
+
+	for name, backend := range map[string]Backend{"remote": remote, "disk": disk} {
+		if err := backend.Get("pipeline", src, src); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected the %s get of a corrupt cache to fail, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package sdstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParentBuilds holds the builds a build was triggered by, keyed by pipeline id, as returned by the Screwdriver API
+type ParentBuilds map[string]struct {
+	EventID *int64            `json:"eventId"`
+	Jobs    map[string]*int64 `json:"jobs"`
+}
+
+// GetParentBuilds fetches the parent builds of the build buildID from the Screwdriver API at apiURL, e.g. SD_API_URL
+func GetParentBuilds(apiURL, token, buildID string, maxRetries, httpTimeout, retryWaitMin, retryWaitMax int) (ParentBuilds, error) {
+	store := NewStore(token, maxRetries, httpTimeout, retryWaitMin, retryWaitMax).(*sdStore)
+
+	body, err := store.request(fmt.Sprintf("%s/builds/%s", strings.TrimRight(apiURL, "/"), buildID), "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	var build struct {
+		ParentBuilds ParentBuilds `json:"parentBuilds"`
+	}
+	if err = json.Unmarshal(body, &build); err != nil {
+		return nil, fmt.Errorf("unparsable build %s from Screwdriver API: %v", buildID, err)
+	}
+
+	return build.ParentBuilds, nil
+}
+
+// BuildID returns the id of the parent build of job, a job of the pipeline pipelineID
+// or sd@<pipeline id>:<job name> for a job of another pipeline
+func (p ParentBuilds) BuildID(pipelineID, job string) (string, error) {
+	name := job
+	if strings.HasPrefix(job, "sd@") {
+		parts := strings.SplitN(strings.TrimPrefix(job, "sd@"), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", fmt.Errorf("invalid job %s, expected <job name> or sd@<pipeline id>:<job name>", job)
+		}
+		pipelineID, name = parts[0], parts[1]
+	}
+
+	if id := p[pipelineID].Jobs[name]; id != nil {
+		return strconv.FormatInt(*id, 10), nil
+	}
+
+	var jobs []string
+	for pid, parent := range p {
+		for jobName, id := range parent.Jobs {
+			if id != nil {
+				jobs = append(jobs, fmt.Sprintf("sd@%s:%s", pid, jobName))
+			}
+		}
+	}
+	sort.Strings(jobs)
+
+	return "", fmt.Errorf("no parent build of job %s, parent builds are of %v", job, jobs)
+}
@@ -0,0 +1,45 @@
+package sdstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testBuild = `{"id": 10038, "parentBuilds": {"123": {"eventId": 9, "jobs": {"main": 555, "lint": null}}, "456": {"eventId": 8, "jobs": {"publish": 777}}}}`
+
+func TestGetParentBuilds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4/builds/10038" || r.Header.Get("Authorization") != "Bearer faketoken" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(testBuild))
+	}))
+	defer server.Close()
+
+	parents, err := GetParentBuilds(server.URL+"/v4/", "faketoken", "10038", 0, 10, 1, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		job  string
+		want string
+	}{
+		{"main", "555"},
+		{"sd@456:publish", "777"},
+	}
+	for _, tt := range tests {
+		got, err := parents.BuildID("123", tt.job)
+		if err != nil || got != tt.want {
+			t.Errorf("BuildID(%s) = %s, %v, want %s", tt.job, got, err, tt.want)
+		}
+	}
+
+	for _, job := range []string{"lint", "publish", "sd@456", "missing"} {
+		if _, err := parents.BuildID("123", job); err == nil {
+			t.Errorf("Expected BuildID(%s) to fail", job)
+		}
+	}
+}
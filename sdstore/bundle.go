@@ -0,0 +1,285 @@
+package sdstore
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bundleMarker is the first entry of an archive bundling several paths, it holds their patterns one per line.
+// The names of the other entries start with the kind of their path: cwd, home or root
+const bundleMarker = ".store-cli-bundle"
+
+// kinds of bundled paths, relative to the current directory, to the home directory, or absolute
+const (
+	bundleCwd  = "cwd"
+	bundleHome = "home"
+	bundleRoot = "root"
+)
+
+// bundleEntry is a file or directory matching a pattern of a bundle, stored under name in its archive
+type bundleEntry struct {
+	path string
+	name string
+}
+
+// IsBundle returns true when the paths of a cache are bundled in one archive: several paths, or a glob pattern
+// matching at least one file or directory. A single path is otherwise cached as is, like any other cache
+func IsBundle(paths []string) bool {
+	if len(paths) != 1 {
+		return len(paths) > 1
+	}
+	if !IsPattern(paths[0]) {
+		return false
+	}
+	_, base, rel, err := bundleBase(paths[0])
+	if err != nil {
+		return false
+	}
+	matches, err := filepath.Glob(filepath.Join(base, rel))
+
+	return err == nil && len(matches) > 0
+}
+
+// IsPattern returns true when path has glob characters, which may match other paths than itself
+func IsPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// BundleKey returns the key a bundle of paths is stored under, the comma separated paths
+func BundleKey(paths []string) string {
+	return strings.Join(paths, ",")
+}
+
+// bundleBase returns the kind of a pattern, the directory it is relative to and the pattern relative to it
+func bundleBase(pattern string) (string, string, string, error) {
+	if strings.HasPrefix(pattern, "~/") {
+		homeDir, err := os.UserHomeDir()
+		return bundleHome, homeDir, filepath.Clean(strings.TrimPrefix(pattern, "~/")), err
+	}
+
+	pattern = filepath.Clean(pattern)
+	if !filepath.IsAbs(pattern) && pattern != ".." && !strings.HasPrefix(pattern, "../") {
+		cwd, err := os.Getwd()
+		return bundleCwd, cwd, pattern, err
+	}
+
+	absPattern, err := filepath.Abs(pattern)
+	return bundleRoot, "/", strings.TrimPrefix(absPattern, "/"), err
+}
+
+// bundleTarget returns the path an entry of a bundle is extracted to
+func bundleTarget(name string) (string, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid bundle entry %s", name)
+	}
+	rel := filepath.Clean(filepath.FromSlash(parts[1]))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("invalid bundle entry %s, outside of its %s directory", name, parts[0])
+	}
+
+	switch parts[0] {
+	case bundleCwd:
+		cwd, err := os.Getwd()
+		return filepath.Join(cwd, rel), err
+	case bundleHome:
+		homeDir, err := os.UserHomeDir()
+		return filepath.Join(homeDir, rel), err
+	case bundleRoot:
+		return filepath.Join("/", rel), nil
+	}
+
+	return "", fmt.Errorf("invalid bundle entry %s", name)
+}
+
+// expandBundle returns the files and directories matching patterns, with their name in the archive.
+// A pattern matching nothing is skipped, but at least one path must match
+func expandBundle(patterns []string) ([]bundleEntry, error) {
+	var entries []bundleEntry
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		kind, base, rel, err := bundleBase(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		matches, err := filepath.Glob(filepath.Join(base, rel))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache path %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			log.Printf("Skipping %s, no such file or directory", pattern)
+			continue
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+
+			relMatch, err := filepath.Rel(base, match)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, bundleEntry{path: match, name: path.Join(kind, filepath.ToSlash(relMatch))})
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no file or directory matches %s", BundleKey(patterns))
+	}
+
+	return entries, nil
+}
+
+// bundleMetadata returns the files of the entries of a bundle but those excluded by ex, the name of each
+// of them in the archive, the md5 of their metadata and their total size
+func bundleMetadata(entries []bundleEntry, ex *excludes) ([]*FileInfo, map[string]string, string, int64) {
+	var (
+		files []*FileInfo
+		size  int64
+	)
+	names := map[string]string{}
+	for _, entry := range entries {
+		fInfos, _, entrySize := getMetadataInfo(entry.path, ex)
+		for _, f := range fInfos {
+			names[f.Path] = entry.name + filepath.ToSlash(strings.TrimPrefix(f.Path, entry.path))
+		}
+		files = append(files, fInfos...)
+		size += entrySize
+	}
+
+	md5Json, _ := json.Marshal(files)
+	return files, names, getMd5(md5Json), size
+}
+
+// bundleMD5 returns the md5 of the files of the entries of a bundle but those excluded by ex, keyed by their
+// name in the archive, along with the patterns of the bundle under bundleMarker
+func bundleMD5(patterns []string, entries []bundleEntry, ex *excludes) (map[string]string, error) {
+	sums := map[string]string{bundleMarker: strings.Join(patterns, "\n")}
+	if ex != nil {
+		sums[excludeMarker] = ex.marker()
+	}
+	for _, entry := range entries {
+		entrySums, err := md5All(entry.path, ex)
+		if err != nil {
+			return nil, err
+		}
+		for filePath, sum := range entrySums {
+			sums[entry.name+filepath.ToSlash(strings.TrimPrefix(filePath, entry.path))] = sum
+		}
+	}
+
+	return sums, nil
+}
+
+// compressBundle writes the files of a bundle to the tar.zst archive dst, after its marker
+func compressBundle(patterns []string, files []*FileInfo, names map[string]string, dst string) error {
+	marker := []byte(strings.Join(patterns, "\n"))
+
+	return compress(dst, files, func(filePath string) string { return names[filePath] }, func(tw *tar.Writer) error {
+		header := &tar.Header{Name: bundleMarker, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(marker)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(marker)
+		return err
+	})
+}
+
+// isBundleArchive returns true when the tar.zst archive at archivePath bundles several paths
+func isBundleArchive(archivePath string) bool {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	zr, err := zstd.NewReader(file)
+	if err != nil {
+		return false
+	}
+	defer zr.Close()
+
+	header, err := tar.NewReader(zr).Next()
+	return err == nil && header.Name == bundleMarker
+}
+
+// uploadBundle compresses the paths matching patterns in one archive and uploads it to u, along with
+// the md5 json of their files recording when the bundle expires, unless their contents and expiry are unchanged
+func (s *sdStore) uploadBundle(u *url.URL, patterns []string, ex *excludes, ttl time.Duration, useExpectHeader bool) error {
+	entries, err := expandBundle(patterns)
+	if err != nil {
+		return err
+	}
+
+	newMd5, err := bundleMD5(patterns, entries, ex)
+	if err != nil {
+		return err
+	}
+	md5URL, err := url.Parse(u.String() + "_md5.json")
+	if err != nil {
+		return err
+	}
+	setExpiry(newMd5, ttl, time.Now())
+	oldMd5, err := s.getMd5Json(md5URL)
+	unchanged := err == nil && sameFiles(oldMd5, newMd5)
+	if unchanged && oldMd5[expiryMarker] == newMd5[expiryMarker] {
+		log.Printf("No change to %s, aborting upload", BundleKey(patterns))
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "store-cli-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	md5JSON, err := json.Marshal(newMd5)
+	if err != nil {
+		return err
+	}
+	md5Path := filepath.Join(dir, "bundle_md5.json")
+	if err = ioutil.WriteFile(md5Path, md5JSON, 0644); err != nil {
+		return err
+	}
+	if err = s.putFile(md5URL, "application/json", md5Path, useExpectHeader); err != nil {
+		log.Printf("failed to upload md5 json of %s", BundleKey(patterns))
+		return err
+	}
+	if unchanged {
+		log.Printf("No change to %s, only its expiry was updated", BundleKey(patterns))
+		return nil
+	}
+
+	archivePath := filepath.Join(dir, "bundle"+CompressFormatTarZst)
+	files, names, _, _ := bundleMetadata(entries, ex)
+	if err = compressBundle(patterns, files, names, archivePath); err != nil {
+		log.Printf("failed to compress files of %s to %s", BundleKey(patterns), archivePath)
+		return err
+	}
+
+	archiveURL, err := url.Parse(u.String() + CompressFormatTarZst)
+	if err != nil {
+		return err
+	}
+	if err = s.upload(archiveURL, "text/plain", archivePath, useExpectHeader); err != nil {
+		log.Printf("failed to upload files %v to store (upload size = %s)", BundleKey(patterns), fileSize(archivePath))
+		return err
+	}
+	log.Printf("Upload to %s successful (%d paths, upload size = %s).", archiveURL.String(), len(entries), fileSize(archivePath))
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package sdstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// bundleFixture creates files in a work directory, which becomes the current directory, in a home directory
+// and at an absolute path. It returns the patterns of the files, their expected contents and a cleanup func
+func bundleFixture(t *testing.T) ([]string, map[string]string, func()) {
+	dir := t.TempDir()
+	workDir := filepath.Join(dir, "work")
+	homeDir := filepath.Join(dir, "home")
+	absPath := filepath.Join(dir, "abs", "tool.bin")
+
+	files := map[string]string{
+		filepath.Join(workDir, "packages/a/node_modules/x.js"): "x",
+		filepath.Join(workDir, "packages/b/node_modules/y.js"): "y",
+		filepath.Join(homeDir, ".gradle/caches/c.bin"):         "c",
+		absPath: "tool",
+	}
+	for path, content := range files {
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		_ = ioutil.WriteFile(path, []byte(content), 0644)
+	}
+
+	wd, _ := os.Getwd()
+	home := os.Getenv("HOME")
+	_ = os.Chdir(workDir)
+	_ = os.Setenv("HOME", homeDir)
+
+	return []string{"packages/*/node_modules", "~/.gradle/caches", absPath, "missing/*"}, files, func() {
+		_ = os.Chdir(wd)
+		_ = os.Setenv("HOME", home)
+	}
+}
+
+func removeFixture(files map[string]string) {
+	for path := range files {
+		_ = os.Remove(path)
+	}
+}
+
+func checkFixture(t *testing.T, files map[string]string) {
+	for path, content := range files {
+		b, err := ioutil.ReadFile(path)
+		if err != nil || string(b) != content {
+			t.Errorf("Expected %q restored at %s, got %q (%v)", content, path, b, err)
+		}
+	}
+}
+
+func TestIsBundle(t *testing.T) {
+	t.Chdir(t.TempDir())
+	_ = os.MkdirAll("packages/a/node_modules", 0755)
+	_ = os.WriteFile("file[1]", []byte("literal"), 0644)
+
+	for paths, expected := range map[string]bool{
+		"node_modules":                   false,
+		"~/.m2":                          false,
+		"node_modules,~/.m2":             true,
+		"packages/*/node_modules":        true,
+		"packages/a/node_modules,vendor": true,
+		// a single pattern matching nothing is a path, cached as is
+		"reports/test-[0-9].xml": false,
+		"file[1]":                false,
+	} {
+		if actual := IsBundle(strings.Split(paths, ",")); actual != expected {
+			t.Errorf("Expected IsBundle(%s) to be %v, got %v", paths, expected, actual)
+		}
+	}
+}
+
+func TestBundleTarget(t *testing.T) {
+	for _, name := range []string{"cwd/../escape", "home/../../escape", "other/file", "cwd"} {
+		if _, err := bundleTarget(name); err == nil {
+			t.Errorf("Expected an error for entry %s", name)
+		}
+	}
+
+	target, err := bundleTarget("root/opt/tool")
+	if err != nil || target != "/opt/tool" {
+		t.Errorf("Expected /opt/tool, got %s (%v)", target, err)
+	}
+}
+
+func TestCompressBundle(t *testing.T) {
+	patterns, files, cleanup := bundleFixture(t)
+	defer cleanup()
+
+	entries, err := expandBundle(patterns)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %v", entries)
+	}
+	sums, _ := bundleMD5(patterns, entries, nil)
+
+	archivePath := filepath.Join(t.TempDir(), "bundle"+CompressFormatTarZst)
+	fInfos, names, _, _ := bundleMetadata(entries, nil)
+	if err = compressBundle(patterns, fInfos, names, archivePath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !isBundleArchive(archivePath) {
+		t.Errorf("Expected %s to be a bundle archive", archivePath)
+	}
+
+	// the entries are extracted to the paths they were compressed from, whatever the destination
+	removeFixture(files)
+	if err = Decompress(archivePath, t.TempDir()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkFixture(t, files)
+
+	restored, _ := bundleMD5(patterns, entries, nil)
+	if !reflect.DeepEqual(sums, restored) {
+		t.Errorf("Expected md5 %v, got %v", sums, restored)
+	}
+
+	if _, err = expandBundle([]string{"missing/*", "nothing"}); err == nil {
+		t.Errorf("Expected an error when no path matches")
+	}
+}
+
+func TestCache2DiskBundle(t *testing.T) {
+	patterns, files, cleanup := bundleFixture(t)
+	defer cleanup()
+	cacheDir := t.TempDir()
+	defer os.Setenv("SD_PIPELINE_CACHE_DIR", os.Getenv("SD_PIPELINE_CACHE_DIR"))
+	_ = os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+	key := BundleKey(patterns)
+
+	if err := Cache2DiskWithOptions("set", "pipeline", key, CacheOptions{Paths: patterns}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := StatCache("pipeline", key); err != nil {
+		t.Errorf("Expected the bundle to be cached: %v", err)
+	}
+
+	removeFixture(files)
+	staging := filepath.Join(t.TempDir(), "bundle")
+	if err := Cache2DiskWithOptions("get", "pipeline", staging, CacheOptions{Key: key}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkFixture(t, files)
+}
+
+func TestUploadBundle(t *testing.T) {
+	patterns, files, cleanup := bundleFixture(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	items := map[string][]byte{}
+	puts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPut {
+			puts++
+			items[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(202)
+			return
+		}
+		if b, ok := items[r.URL.Path]; ok {
+			_, _ = w.Write(b)
+			return
+		}
+		w.WriteHeader(404)
+	}))
+	defer server.Close()
+
+	store := newStore(0)
+	u, _ := url.Parse(server.URL + "/v1/caches/pipelines/1/" + url.PathEscape(BundleKey(patterns)))
+	options := UploadOptions{Compress: true, Paths: patterns}
+	if err := store.UploadWithOptions(u, BundleKey(patterns), options); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if puts != 2 {
+		t.Errorf("Expected the md5 json and the archive to be uploaded, got %d uploads", puts)
+	}
+
+	var sums map[string]string
+	_ = json.Unmarshal(items[u.Path+"_md5.json"], &sums)
+	if sums[bundleMarker] != strings.Join(patterns, "\n") || sums["cwd/packages/a/node_modules/x.js"] == "" || sums["home/.gradle/caches/c.bin"] == "" {
+		t.Errorf("Unexpected md5 json %v", sums)
+	}
+
+	// unchanged paths are not uploaded again
+	if err := store.UploadWithOptions(u, BundleKey(patterns), options); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if puts != 2 {
+		t.Errorf("Expected no upload of unchanged paths, got %d uploads", puts)
+	}
+
+	removeFixture(files)
+	if err := store.DownloadTo(u, filepath.Join(t.TempDir(), "bundle"), true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkFixture(t, files)
+	if err := store.Verify(u, filepath.Join(t.TempDir(), "bundle")); err != nil {
+		t.Errorf("Unexpected verification error: %v", err)
+	}
+}
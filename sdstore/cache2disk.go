@@ -28,6 +28,9 @@ const Md5Extension = ".md5"
 const DefaultFilePermission = os.ModePerm
 const ZstdCli = true // use zstd binary or go library
 
+// ZstdLevel overrides CompressionLevel with a zstd level from 1 to 19 when compressing caches, 0 keeps CompressionLevel
+var ZstdLevel = 0
+
 var FlockWaitMinSecs = 5
 var FlockWaitMaxSecs = 15
 
@@ -58,6 +61,26 @@ func executeCommand(command string) error {
 	return nil
 }
 
+// executeExtractCommand : Execute the shell command extracting a cache
+// return output => executing shell command succeeds
+// return error => when the command fails, its output on stderr alone is only a warning, e.g. from tar
+func executeExtractCommand(command string) error {
+	logger.Info(command)
+	cmd := ExecCommand("sh", "-c", command)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return logger.Error(fmt.Errorf("error: %v, %v, out: %v", err, stderr.String(), stdout.String()))
+	}
+	if strings.TrimSpace(stderr.String()) != "" {
+		logger.Warn(fmt.Sprintf("command warning: %v", stderr.String()))
+	}
+	logger.Info("command output: " + stdout.String())
+	return nil
+}
+
 // releaseLock : release lock
 // return error => for any error
 func releaseLock(path string) {
@@ -102,6 +125,22 @@ func acquireLock(path string, read bool) error {
 	return errors.New("max attempts exceeded")
 }
 
+// zstdLevel returns the level of the zstd encoder: ZstdLevel when set, CompressionLevel otherwise
+func zstdLevel() int {
+	if ZstdLevel > 0 {
+		return ZstdLevel
+	}
+	return CompressionLevel
+}
+
+// zstdFlag returns the level flag of the zstd binary, e.g. -19 for ZstdLevel 19, CompressionLevel otherwise
+func zstdFlag() int {
+	if ZstdLevel > 0 {
+		return -ZstdLevel
+	}
+	return CompressionLevel
+}
+
 // ZStandard from https://github.com/facebook/zstd
 // To test in mac - download from https://github.com/screwdriver-cd/sd-packages/releases/download/v0.0.30/zstd-cli-macosx.tar.gz and set path
 // To test in linux - download from https://github.com/screwdriver-cd/sd-packages/releases/download/v0.0.30/zstd-cli-linux.tar.gz and set path
@@ -157,13 +196,17 @@ func writeMd5(dst, md5 string) {
 Get metadata and return md5 and total size for the given path.
 using file meta instead of calculating md5 for each file content, as its slower than storing the cache
 param - path         		file / folder path
+param - ex			files and directories left out, nil for none
 return - string / int64 	md5 for path / total size in bytes
 */
-func getMetadataInfo(path string) ([]*FileInfo, string, int64) {
+func getMetadataInfo(path string, ex *excludes) ([]*FileInfo, string, int64) {
 	var fileInfos []*FileInfo
 
 	err := godirwalk.Walk(path, &godirwalk.Options{
 		Callback: func(filePath string, de *godirwalk.Dirent) error {
+			if ex.skip(path, filePath, de.IsDir()) {
+				return godirwalk.SkipThis
+			}
 			size := int64(0)
 			file, err := os.Lstat(filePath)
 			if err != nil || file == nil {
@@ -198,6 +241,22 @@ func getMetadataInfo(path string) ([]*FileInfo, string, int64) {
 	return fileInfos, getMd5(md5Json), size
 }
 
+/*
+count the files listed by getMetadataInfo, leaving out directories
+param - fInfos			files and directories
+return - int			number of files
+*/
+func countFiles(fInfos []*FileInfo) int {
+	count := 0
+	for _, f := range fInfos {
+		if !strings.HasPrefix(f.Mode, "d") {
+			count++
+		}
+	}
+
+	return count
+}
+
 /*
 compare md5 of files for source and destination directories
 param - newMd5         	md5 of source
@@ -247,6 +306,45 @@ func removeCacheDirectory(path, md5Path string) {
 	}
 }
 
+/*
+get the path of the metadata file of a cache, next to its .md5 file
+param - cache         		cache path in the shared file server
+return - string / bool   	metadata file path / false if the cache has no .md5 file
+*/
+func diskMetaPath(cache string) (string, bool) {
+	// a directory is cached inside itself, a file next to itself
+	for _, dir := range []string{cache, filepath.Dir(cache)} {
+		if _, err := os.Lstat(filepath.Join(dir, fmt.Sprintf("%s%s", filepath.Base(cache), Md5Extension))); err == nil {
+			return filepath.Join(dir, fmt.Sprintf("%s%s", filepath.Base(cache), MetaExtension)), true
+		}
+	}
+
+	return "", false
+}
+
+/*
+check the expiry of a cache in the shared file server
+param - cache         		cache path in the shared file server
+param - key				key the cache is stored under
+return - nil / error   		not expired - return nil; expired - return error wrapping ErrNotFound
+*/
+func checkDiskExpiry(cache, key string) error {
+	metaPath, ok := diskMetaPath(cache)
+	if !ok {
+		return nil
+	}
+	meta, err := readCacheMeta(metaPath)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("ignoring cache metadata: %v", err))
+		return nil
+	}
+	if meta.Expired(time.Now()) {
+		return expiredError(key, meta)
+	}
+
+	return nil
+}
+
 /*
 get cache from shared file server to local
 param - src         		source directory
@@ -256,7 +354,7 @@ return - nil / error   		success - return nil; error - return error description
 */
 func getCache(src, dest, command string) error {
 	var (
-		cwd, msg, srcZipPath, destPath, compressFormat string
+		msg, srcZipPath, destPath, compressFormat string
 	)
 	logger.Info("get cache")
 	info, err := os.Lstat(src)
@@ -274,7 +372,9 @@ func getCache(src, dest, command string) error {
 			// backward-compatibility to look for .zip file if .tar.zst is missing
 			info, err = os.Lstat(fmt.Sprintf("%s%s", src, CompressFormatZip))
 			if err != nil {
-				return logger.Error(fmt.Errorf("file %v not found, command: %v", fmt.Sprintf("%s%s", src, CompressFormatZip), command))
+				err = fmt.Errorf("file %v not found, command: %v: %w", fmt.Sprintf("%s%s", src, CompressFormatZip), command, ErrNotFound)
+				_ = logger.Error(err)
+				return err
 			}
 		}
 	}
@@ -308,15 +408,12 @@ func getCache(src, dest, command string) error {
 		_, err = os.Lstat(srcZipPath)
 		if err == nil {
 			// if .tar.zst exist then
-			cwd, err = os.Getwd()
-			if err != nil {
-				return logger.Error(err)
-			}
 			_ = os.MkdirAll(destPath, DefaultFilePermission)
 			if err = acquireLock(srcZipPath, true); err == nil {
-				if ZstdCli {
-					cmd := fmt.Sprintf("cd %s && %s -cd -T0 %d %s | tar xf - || true; cd %s", destPath, getZstdBinary(), CompressionLevel, srcZipPath, cwd)
-					err = executeCommand(cmd)
+				// the entries of a bundle are extracted to the paths they were set from, which only Decompress knows
+				if ZstdCli && !isBundleArchive(srcZipPath) {
+					cmd := fmt.Sprintf("cd %s && %s -cd -T0 %d %s | tar xf -", destPath, getZstdBinary(), CompressionLevel, srcZipPath)
+					err = executeExtractCommand(cmd)
 				} else {
 					err = Decompress(srcZipPath, destPath)
 				}
@@ -352,6 +449,9 @@ func getCache(src, dest, command string) error {
 			defer os.RemoveAll(filepath.Join(dest, fmt.Sprintf("%s%s", filepath.Base(dest), Md5Extension)))
 		}
 	}
+	if archive, err := os.Stat(srcZipPath); err == nil {
+		recordTransfer(0, archive.Size())
+	}
 	fmt.Println("get cache SUCCESS")
 	logger.Info("get cache complete")
 
@@ -364,9 +464,10 @@ param - src         		source directory
 param - dest			destination directory
 param -	command			set
 param - cacheMaxSizeInMB	max cache size limit allowed in MB
+param - ex			files and directories left out, nil for none
 return - nil / error   		success - return nil; error - return error description
 */
-func setCache(src, dest, command string, cacheMaxSizeInMB int64) error {
+func setCache(src, dest, command string, cacheMaxSizeInMB int64, ex *excludes) error {
 	var (
 		msg, md5Path, destPath, destBase, srcPath, srcFile, cwd string
 		err                                                     error
@@ -386,7 +487,7 @@ func setCache(src, dest, command string, cacheMaxSizeInMB int64) error {
 		srcFile = filepath.Base(src)
 	}
 
-	fInfos, newMd5, sizeInBytes := getMetadataInfo(src)
+	fInfos, newMd5, sizeInBytes := getMetadataInfo(src, ex)
 	if cacheMaxSizeInMB > 0 {
 		cacheMaxSizeInBytes := cacheMaxSizeInMB << (10 * 2) // MB to Bytes
 		fmt.Printf("size: %v B\n", sizeInBytes)
@@ -408,9 +509,10 @@ func setCache(src, dest, command string, cacheMaxSizeInMB int64) error {
 	}
 	_ = os.MkdirAll(destPath, DefaultFilePermission)
 
-	if ZstdCli {
+	// tar does not know the exclude patterns, the files left after excluding are compressed by Compress
+	if ZstdCli && ex == nil {
 		if err = acquireLock(targetPath, false); err == nil {
-			cmd := fmt.Sprintf("cd %s && tar -c %s | %s -T0 %d > %s || true; cd %s", srcPath, srcFile, getZstdBinary(), CompressionLevel, targetPath, cwd)
+			cmd := fmt.Sprintf("cd %s && tar -c %s | %s -T0 %d > %s || true; cd %s", srcPath, srcFile, getZstdBinary(), zstdFlag(), targetPath, cwd)
 			err = executeCommand(cmd)
 			if err != nil {
 				msg = fmt.Sprintf("failed to compress files from %v", src)
@@ -419,6 +521,9 @@ func setCache(src, dest, command string, cacheMaxSizeInMB int64) error {
 			_ = os.Chmod(destPath, DefaultFilePermission)
 			_ = os.Chmod(targetPath, DefaultFilePermission)
 			releaseLock(targetPath)
+			if archive, err := os.Stat(targetPath); err == nil {
+				recordArchive(archive.Size(), sizeInBytes)
+			}
 		} else {
 			return logger.Error(fmt.Errorf("unable to acquire lock on file: %v, error: %v", targetPath, err))
 		}
@@ -434,6 +539,9 @@ func setCache(src, dest, command string, cacheMaxSizeInMB int64) error {
 			return logger.Error(err)
 		}
 	}
+	if archive, err := os.Stat(targetPath); err == nil {
+		recordTransfer(archive.Size(), 0)
+	}
 	// remove zip file if available
 	targetPath = fmt.Sprintf("%s%s", filepath.Join(destPath, destBase), CompressFormatZip)
 	defer os.RemoveAll(targetPath)
@@ -449,31 +557,70 @@ func setCache(src, dest, command string, cacheMaxSizeInMB int64) error {
 }
 
 /*
-cache directories and files to/from shared storage
-param - command         	set, get or remove
-param - cacheScope     		pipeline, event, job
-param -	src     		source directory
+set the paths matching patterns as one bundle archive to shared storage
+param - patterns		paths and glob patterns bundled together
+param - dest			cache path in shared storage, stored as a file cache
 param - cacheMaxSizeInMB	max cache size limit allowed in MB
-return - nil / error   success - return nil; error - return error description
+param - ex			files and directories left out, nil for none
+return - nil / error   	success - return nil; error - return error description
 */
-func Cache2Disk(command, cacheScope, src string, cacheMaxSizeInMB int64) error {
-	var (
-		info os.FileInfo
-		err  error
-	)
+func setBundleCache(patterns []string, dest string, cacheMaxSizeInMB int64, ex *excludes) error {
+	entries, err := expandBundle(patterns)
+	if err != nil {
+		return logger.Error(err)
+	}
 
-	homeDir, _ := os.UserHomeDir()
-	baseCacheDir := ""
-	command = strings.ToLower(strings.TrimSpace(command))
-	cacheScope = strings.ToLower(strings.TrimSpace(cacheScope))
+	fInfos, names, newMd5, sizeInBytes := bundleMetadata(entries, ex)
+	if cacheMaxSizeInMB > 0 {
+		cacheMaxSizeInBytes := cacheMaxSizeInMB << (10 * 2) // MB to Bytes
+		if sizeInBytes > cacheMaxSizeInBytes {
+			return logger.Error(fmt.Errorf("source paths size %v B is more than allowed max limit %v B", sizeInBytes, cacheMaxSizeInBytes))
+		}
+		logger.Info(fmt.Sprintf("source paths size %vB, allowed max limit %vB", sizeInBytes, cacheMaxSizeInBytes))
+	}
 
-	if command != "set" && command != "get" && command != "remove" {
-		return logger.Error(fmt.Errorf("%v, command: %v is not expected", err, command))
+	destPath := filepath.Dir(dest)
+	destBase := filepath.Base(dest)
+	if compareMd5(newMd5, destPath, destBase) {
+		logger.Warn(fmt.Sprintf("source paths %s and destination %s are same, aborting", BundleKey(patterns), dest))
+		return nil
 	}
 
-	if cacheScope == "" {
-		return logger.Error(fmt.Errorf("%v, cache scope %v empty", err, cacheScope))
+	targetPath := fmt.Sprintf("%s%s", dest, CompressFormatTarZst)
+	_ = os.MkdirAll(destPath, DefaultFilePermission)
+	if err = acquireLock(targetPath, false); err != nil {
+		return logger.Error(err)
+	}
+	err = compressBundle(patterns, fInfos, names, targetPath)
+	_ = os.Chmod(targetPath, DefaultFilePermission)
+	releaseLock(targetPath)
+	if err != nil {
+		return logger.Error(err)
 	}
+	if archive, err := os.Stat(targetPath); err == nil {
+		recordTransfer(archive.Size(), 0)
+	}
+
+	md5Path := filepath.Join(destPath, fmt.Sprintf("%s%s", destBase, Md5Extension))
+	if err = acquireLock(md5Path, false); err != nil {
+		return logger.Error(err)
+	}
+	writeMd5(md5Path, newMd5)
+	releaseLock(md5Path)
+
+	return nil
+}
+
+/*
+get the cache directory of a scope from SD_PIPELINE_CACHE_DIR, SD_EVENT_CACHE_DIR or SD_JOB_CACHE_DIR
+param - cacheScope     		pipeline, event, job
+return - string / error   	absolute cache directory / error if it does not exist
+*/
+func getBaseCacheDir(cacheScope string) (string, error) {
+	var (
+		baseCacheDir string
+		err          error
+	)
 
 	switch cacheScope {
 	case "pipeline":
@@ -485,41 +632,296 @@ func Cache2Disk(command, cacheScope, src string, cacheMaxSizeInMB int64) error {
 	}
 
 	if strings.HasPrefix(baseCacheDir, "~/") {
+		homeDir, _ := os.UserHomeDir()
 		baseCacheDir = filepath.Join(homeDir, strings.TrimPrefix(baseCacheDir, "~/"))
 	}
+	if baseCacheDir, err = filepath.Abs(baseCacheDir); err != nil {
+		return "", fmt.Errorf("%v in path %v", err, baseCacheDir)
+	}
+
+	if _, err := os.Lstat(baseCacheDir); err != nil {
+		return "", fmt.Errorf("%v, cache path %s not found", err, baseCacheDir)
+	}
+
+	return baseCacheDir, nil
+}
+
+/*
+get the path of the cache stored under key with the disk strategy
+param - scope			pipeline, event, job
+param - key			key of the cache
+return - string / error		path of the cache / error if the key or the cache directory is invalid
+*/
+func diskCachePath(scope, key string) (string, error) {
+	key, err := normalizeSrc(key)
+	if err != nil {
+		return "", err
+	}
+	baseCacheDir, err := getBaseCacheDir(strings.ToLower(strings.TrimSpace(scope)))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(baseCacheDir, key), nil
+}
+
+/*
+expand ~/ and ../ in a cache path, the way the cache is stored in the shared file server
+param - src			cache path
+return - string / error   	cleaned path / error description
+*/
+func normalizeSrc(src string) (string, error) {
+	var err error
+
 	if strings.HasPrefix(src, "~/") {
+		homeDir, _ := os.UserHomeDir()
 		src = filepath.Join(homeDir, strings.TrimPrefix(src, "~/"))
 	}
 	src = filepath.Clean(src)
 	if strings.HasPrefix(src, "../") {
 		if src, err = filepath.Abs(src); err != nil {
-			return logger.Error(fmt.Errorf("%v in src path %v, command: %v", err, src, command))
+			return src, fmt.Errorf("%v in src path %v", err, src)
 		}
 	}
-	if baseCacheDir, err = filepath.Abs(baseCacheDir); err != nil {
-		return logger.Error(fmt.Errorf("%v in path %v, command: %v", err, baseCacheDir, command))
+
+	return src, nil
+}
+
+/*
+stat the archive and .md5 file of a cache in the shared file server
+param - cacheScope     		pipeline, event, job
+param - src     		cache path
+return - *ItemInfo / error   	success - return archive size and modified time; error - wraps ErrNotFound when missing
+*/
+func StatCache(cacheScope, src string) (*ItemInfo, error) {
+	var err error
+
+	cacheScope = strings.ToLower(strings.TrimSpace(cacheScope))
+	if cacheScope == "" {
+		return nil, logger.Error(fmt.Errorf("cache scope %v empty", cacheScope))
 	}
 
-	if _, err := os.Lstat(baseCacheDir); err != nil {
-		return logger.Error(fmt.Errorf("%v, cache path %s not found", err, baseCacheDir))
+	if src, err = normalizeSrc(src); err != nil {
+		return nil, logger.Error(err)
+	}
+
+	baseCacheDir, err := getBaseCacheDir(cacheScope)
+	if err != nil {
+		return nil, logger.Error(err)
 	}
 
 	cache := filepath.Join(baseCacheDir, src)
+	if err := checkDiskExpiry(cache, src); err != nil {
+		return nil, err
+	}
+
+	// a directory is cached inside itself, a file next to itself
+	for _, dir := range []string{cache, filepath.Dir(cache)} {
+		md5Path := filepath.Join(dir, fmt.Sprintf("%s%s", filepath.Base(cache), Md5Extension))
+		if _, err := os.Lstat(md5Path); err != nil {
+			continue
+		}
+
+		for _, format := range []string{CompressFormatTarZst, CompressFormatZip} {
+			info, err := os.Lstat(filepath.Join(dir, fmt.Sprintf("%s%s", filepath.Base(cache), format)))
+			if err == nil {
+				return &ItemInfo{Key: src, Size: info.Size(), LastModified: info.ModTime()}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("cache %v not found in %v: %w", src, baseCacheDir, ErrNotFound)
+}
+
+/*
+list the caches stored in the shared file server for a scope, every .tar.zst or .zip archive with its .md5 file is one cache
+param - cacheScope     		pipeline, event, job
+return - []ItemInfo / error   	success - return the caches; error - return error description
+*/
+func ListCache(cacheScope string) ([]ItemInfo, error) {
+	var caches []ItemInfo
+
+	cacheScope = strings.ToLower(strings.TrimSpace(cacheScope))
+	if cacheScope == "" {
+		return nil, logger.Error(fmt.Errorf("cache scope %v empty", cacheScope))
+	}
+
+	baseCacheDir, err := getBaseCacheDir(cacheScope)
+	if err != nil {
+		return nil, logger.Error(err)
+	}
+
+	err = godirwalk.Walk(baseCacheDir, &godirwalk.Options{
+		Callback: func(filePath string, de *godirwalk.Dirent) error {
+			if de.IsDir() {
+				return nil
+			}
+
+			dir, name := filepath.Split(filePath)
+			for _, format := range []string{CompressFormatTarZst, CompressFormatZip} {
+				if !strings.HasSuffix(name, format) {
+					continue
+				}
+
+				base := strings.TrimSuffix(name, format)
+				if _, err := os.Lstat(filepath.Join(dir, fmt.Sprintf("%s%s", base, Md5Extension))); err != nil {
+					return nil
+				}
+				info, err := os.Lstat(filePath)
+				if err != nil {
+					return nil
+				}
+
+				// a directory is cached inside itself, a file next to itself
+				cache := filepath.Join(dir, base)
+				if base == filepath.Base(dir) {
+					cache = filepath.Clean(dir)
+				}
+				key, err := filepath.Rel(baseCacheDir, cache)
+				if err != nil {
+					return nil
+				}
+				// the key of a cache set from a relative path is recorded in its metadata, as the remote store keeps it
+				key = "/" + key
+				if meta, err := readCacheMeta(filepath.Join(dir, fmt.Sprintf("%s%s", base, MetaExtension))); err == nil && meta.Key != "" {
+					key = meta.Key
+				}
+
+				caches = append(caches, ItemInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+				return nil
+			}
+
+			return nil
+		},
+		ErrorCallback: func(filePath string, err error) godirwalk.ErrorAction {
+			logger.Warn(err)
+			return godirwalk.SkipNode
+		},
+		Unsorted:            false,
+		FollowSymbolicLinks: false,
+	})
+	if err != nil {
+		return nil, logger.Error(err)
+	}
+
+	return caches, nil
+}
+
+/*
+cache directories and files to/from shared storage
+param - command         	set, get or remove
+param - cacheScope     		pipeline, event, job
+param -	src     		source directory
+param - cacheMaxSizeInMB	max cache size limit allowed in MB
+return - nil / error   success - return nil; error - return error description
+*/
+func Cache2Disk(command, cacheScope, src string, cacheMaxSizeInMB int64) error {
+	return Cache2DiskWithOptions(command, cacheScope, src, CacheOptions{MaxSizeInMB: cacheMaxSizeInMB})
+}
+
+// CacheOptions holds the optional settings of a cache in the shared file server
+type CacheOptions struct {
+	// MaxSizeInMB is the max cache size limit allowed in MB, 0 for no limit
+	MaxSizeInMB int64
+	// Key is the key the cache is stored under, src when empty
+	Key string
+	// TTL is how long a cache being set stays valid, 0 for no expiry
+	TTL time.Duration
+	// Paths are bundled in one archive instead of src when setting, see IsBundle
+	Paths []string
+	// Exclude are gitignore-style patterns of the files and directories left out of a cache being set
+	Exclude []string
+	// Strict returns the error of a cache which cannot be restored instead of only logging it. A missing or
+	// expired cache is an error wrapping ErrNotFound
+	Strict bool
+}
+
+/*
+cache directories and files to/from shared storage, under the key in options
+param - command         	set, get or remove
+param - cacheScope     		pipeline, event, job
+param -	src     		source directory
+param - options			key and max cache size of the cache
+return - nil / error   success - return nil; error - return error description
+*/
+func Cache2DiskWithOptions(command, cacheScope, src string, options CacheOptions) error {
+	var (
+		info os.FileInfo
+		err  error
+	)
+	cacheMaxSizeInMB := options.MaxSizeInMB
+
+	command = strings.ToLower(strings.TrimSpace(command))
+	cacheScope = strings.ToLower(strings.TrimSpace(cacheScope))
+
+	if command != "set" && command != "get" && command != "remove" {
+		return logger.Error(fmt.Errorf("%v, command: %v is not expected", err, command))
+	}
+
+	if cacheScope == "" {
+		return logger.Error(fmt.Errorf("%v, cache scope %v empty", err, cacheScope))
+	}
+
+	if src, err = normalizeSrc(src); err != nil {
+		return logger.Error(fmt.Errorf("%v, command: %v", err, command))
+	}
+
+	baseCacheDir, err := getBaseCacheDir(cacheScope)
+	if err != nil {
+		return logger.Error(fmt.Errorf("%v, command: %v", err, command))
+	}
+
+	key := src
+	if options.Key != "" {
+		if key, err = normalizeSrc(options.Key); err != nil {
+			return logger.Error(fmt.Errorf("%v, command: %v", err, command))
+		}
+	}
+
+	cache := filepath.Join(baseCacheDir, key)
 	dest := cache
 
 	switch command {
 	case "set":
 		fmt.Printf("set cache -> {scope: %v, path: %v} \n", cacheScope, src)
-		if err = setCache(src, dest, command, cacheMaxSizeInMB); err != nil {
+		ex, err := newExcludes(options.Exclude)
+		if err != nil {
+			return logger.Error(err)
+		}
+		if len(options.Paths) > 0 {
+			err = setBundleCache(options.Paths, dest, cacheMaxSizeInMB, ex)
+		} else {
+			err = setCache(src, dest, command, cacheMaxSizeInMB, ex)
+		}
+		if err != nil {
 			return logger.Error(fmt.Errorf("set cache FAILED"))
 		}
+		if metaPath, ok := diskMetaPath(cache); ok {
+			meta := newCacheMeta(options.TTL, time.Now())
+			if !filepath.IsAbs(key) {
+				meta.Key = key
+			}
+			if err = writeCacheMeta(metaPath, meta); err != nil {
+				return logger.Error(fmt.Errorf("failed to write cache metadata %v: %v", metaPath, err))
+			}
+		}
 		fmt.Println("set cache SUCCESS")
 	case "get":
 		dest = src
 		src = cache
 		fmt.Printf("get cache -> {scope: %v, path: %v} \n", cacheScope, src)
+		if err = checkDiskExpiry(cache, key); err != nil {
+			logger.Warn(fmt.Sprintf("get cache FAILED, %v", err))
+			if options.Strict {
+				return err
+			}
+			return nil
+		}
 		if err = getCache(src, dest, command); err != nil {
 			logger.Warn(fmt.Sprintf("get cache FAILED"))
+			if options.Strict {
+				return err
+			}
 		}
 	case "remove":
 		fmt.Printf("remove cache -> {scope: %v, path: %v} \n", cacheScope, src)
@@ -536,6 +938,7 @@ func Cache2Disk(command, cacheScope, src string, cacheMaxSizeInMB int64) error {
 			}
 
 			removeCacheDirectory(dest, filepath.Join(destPath, fmt.Sprintf("%s%s", destBase, Md5Extension)))
+			_ = os.RemoveAll(filepath.Join(destPath, fmt.Sprintf("%s%s", destBase, MetaExtension)))
 		}
 		fmt.Println("remove cache SUCCESS")
 	}
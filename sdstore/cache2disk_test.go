@@ -1,6 +1,7 @@
 package sdstore
 
 import (
+	"errors"
 	"fmt"
 	copy2 "github.com/otiai10/copy"
 	"gotest.tools/assert"
@@ -685,6 +686,193 @@ func Test_GetCache_NewRelativeFolder_wCompress_GoLib(t *testing.T) {
 	_ = os.Chdir(origDir)
 }
 
+func TestListCache(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "listcache")
+	defer os.RemoveAll(cacheDir)
+	_ = os.Setenv("SD_EVENT_CACHE_DIR", cacheDir)
+
+	// directory cache
+	_ = os.MkdirAll(filepath.Join(cacheDir, "tmp/node_modules"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/node_modules/node_modules.tar.zst"), []byte("archive"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/node_modules/node_modules.md5"), []byte("md5"), 0777)
+	// file cache
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/file.txt.zip"), []byte("zip"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/file.txt.md5"), []byte("md5"), 0777)
+	// relative directory cache
+	_ = os.MkdirAll(filepath.Join(cacheDir, "vendor"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "vendor/vendor.tar.zst"), []byte("vendor"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "vendor/vendor.md5"), []byte("md5"), 0777)
+	// archive without md5
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/orphan.tar.zst"), []byte("archive"), 0777)
+
+	// the caches without a recorded key were set from absolute paths
+	caches, err := ListCache("event")
+	assert.NilError(t, err)
+	assert.Equal(t, len(caches), 3)
+	assert.Equal(t, caches[0].Key, "/tmp/file.txt")
+	assert.Equal(t, caches[0].Size, int64(3))
+	assert.Equal(t, caches[1].Key, "/tmp/node_modules")
+	assert.Equal(t, caches[1].Size, int64(7))
+	assert.Equal(t, caches[2].Key, "/vendor")
+	assert.Equal(t, caches[2].Size, int64(6))
+
+	_, err = ListCache("")
+	assert.ErrorContains(t, err, "cache scope  empty")
+}
+
+// the keys listed are those get restores the caches with, absolute or relative like the remote store
+func TestListCacheRoundTrip(t *testing.T) {
+	cacheDir := t.TempDir()
+	workDir := t.TempDir()
+	t.Setenv("SD_EVENT_CACHE_DIR", cacheDir)
+	t.Chdir(workDir)
+
+	abs := filepath.Join(workDir, "abs")
+	for _, src := range []string{"rel", abs} {
+		_ = os.MkdirAll(src, 0777)
+		_ = ioutil.WriteFile(filepath.Join(src, "file"), []byte(filepath.Base(src)), 0777)
+		assert.NilError(t, Cache2DiskWithOptions("set", "event", src, CacheOptions{}))
+	}
+
+	caches, err := ListCache("event")
+	assert.NilError(t, err)
+	var keys []string
+	for _, cache := range caches {
+		keys = append(keys, cache.Key)
+	}
+	assert.DeepEqual(t, keys, []string{"rel", abs})
+
+	for _, key := range keys {
+		_ = os.RemoveAll(key)
+		assert.NilError(t, Cache2DiskWithOptions("get", "event", key, CacheOptions{Strict: true}))
+		content, err := ioutil.ReadFile(filepath.Join(key, "file"))
+		assert.NilError(t, err)
+		assert.Equal(t, string(content), filepath.Base(key))
+	}
+}
+
+func TestStatCache(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "statcache")
+	defer os.RemoveAll(cacheDir)
+	_ = os.Setenv("SD_JOB_CACHE_DIR", cacheDir)
+
+	_ = os.MkdirAll(filepath.Join(cacheDir, "tmp/node_modules"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/node_modules/node_modules.tar.zst"), []byte("archive"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/node_modules/node_modules.md5"), []byte("md5"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/file.txt.zip"), []byte("zip"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/file.txt.md5"), []byte("md5"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, "tmp/orphan.tar.zst"), []byte("archive"), 0777)
+
+	info, err := StatCache("job", "/tmp/node_modules/")
+	assert.NilError(t, err)
+	assert.Equal(t, info.Key, "/tmp/node_modules")
+	assert.Equal(t, info.Size, int64(7))
+
+	info, err = StatCache("job", "/tmp/file.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, info.Size, int64(3))
+
+	_, err = StatCache("job", "/tmp/orphan")
+	assert.Assert(t, errors.Is(err, ErrNotFound))
+
+	_, err = StatCache("job", "/tmp/missing")
+	assert.Assert(t, errors.Is(err, ErrNotFound))
+}
+
 func Test_RemoveCache_Folders(t *testing.T) {
 	removeCacheFolders()
 }
+
+func TestCache2DiskWithKey(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "keycache")
+	defer os.RemoveAll(cacheDir)
+	workDir, _ := ioutil.TempDir("", "keysrc")
+	defer os.RemoveAll(workDir)
+	_ = os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+
+	src := filepath.Join(workDir, "node_modules")
+	_ = os.MkdirAll(src, 0777)
+	_ = ioutil.WriteFile(filepath.Join(src, "module.js"), []byte("v1"), 0777)
+
+	err := Cache2DiskWithOptions("set", "pipeline", src, CacheOptions{Key: src + "@v1"})
+	assert.NilError(t, err)
+	_, err = os.Stat(filepath.Join(cacheDir, src+"@v1", "node_modules@v1"+CompressFormat))
+	assert.NilError(t, err)
+
+	_ = os.RemoveAll(src)
+	err = Cache2DiskWithOptions("get", "pipeline", src, CacheOptions{Key: src + "@v1"})
+	assert.NilError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(src, "module.js"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "v1")
+
+	info, err := StatCache("pipeline", src+"@v1")
+	assert.NilError(t, err)
+	assert.Equal(t, info.Key, src+"@v1")
+}
+
+// a warning of tar on stderr, like a time stamp in the future, does not fail the get
+func TestCache2DiskGetWithTarWarning(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "warncache")
+	defer os.RemoveAll(cacheDir)
+	workDir, _ := ioutil.TempDir("", "warnsrc")
+	defer os.RemoveAll(workDir)
+	_ = os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+
+	src := filepath.Join(workDir, "node_modules")
+	_ = os.MkdirAll(src, 0777)
+	_ = ioutil.WriteFile(filepath.Join(src, "module.js"), []byte("v1"), 0777)
+	future := time.Now().Add(24 * time.Hour)
+	_ = os.Chtimes(filepath.Join(src, "module.js"), future, future)
+
+	err := Cache2DiskWithOptions("set", "pipeline", src, CacheOptions{})
+	assert.NilError(t, err)
+
+	_ = os.RemoveAll(src)
+	err = Cache2DiskWithOptions("get", "pipeline", src, CacheOptions{Strict: true})
+	assert.NilError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(src, "module.js"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "v1")
+}
+
+func TestCache2DiskExpiry(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "ttlcache")
+	defer os.RemoveAll(cacheDir)
+	workDir, _ := ioutil.TempDir("", "ttlsrc")
+	defer os.RemoveAll(workDir)
+	_ = os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+
+	src := filepath.Join(workDir, "node_modules")
+	_ = os.MkdirAll(src, 0777)
+	_ = ioutil.WriteFile(filepath.Join(src, "module.js"), []byte("v1"), 0777)
+
+	err := Cache2DiskWithOptions("set", "pipeline", src, CacheOptions{TTL: time.Hour})
+	assert.NilError(t, err)
+	metaPath := filepath.Join(cacheDir, src, "node_modules"+MetaExtension)
+	meta, err := readCacheMeta(metaPath)
+	assert.NilError(t, err)
+	assert.Assert(t, meta.ExpiresAt != nil && !meta.Expired(time.Now()))
+	_, err = StatCache("pipeline", src)
+	assert.NilError(t, err)
+
+	// an expired cache is a miss
+	_ = ioutil.WriteFile(metaPath, []byte(`{"expiresAt":"2020-01-01T00:00:00Z"}`), 0777)
+	_, err = StatCache("pipeline", src)
+	assert.Assert(t, errors.Is(err, ErrNotFound))
+	_ = os.RemoveAll(src)
+	err = Cache2DiskWithOptions("get", "pipeline", src, CacheOptions{})
+	assert.NilError(t, err)
+	_, err = os.Stat(src)
+	assert.Assert(t, os.IsNotExist(err))
+
+	// setting the cache again without a ttl removes its expiry
+	_ = os.MkdirAll(src, 0777)
+	_ = ioutil.WriteFile(filepath.Join(src, "module.js"), []byte("v2"), 0777)
+	err = Cache2DiskWithOptions("set", "pipeline", src, CacheOptions{})
+	assert.NilError(t, err)
+	_, err = os.Stat(metaPath)
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = StatCache("pipeline", src)
+	assert.NilError(t, err)
+}
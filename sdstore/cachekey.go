@@ -0,0 +1,98 @@
+package sdstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// length of the hashes put in cache keys, in hex digits
+const keyHashLength = 16
+
+var hashTemplate = regexp.MustCompile(`\{hash:([^}]*)\}`)
+
+// hashFiles returns the hash of the contents of the files matching the comma separated glob patterns,
+// in the order of the patterns. Every pattern must match at least one file
+func hashFiles(patterns string) (string, error) {
+	keyHash := sha256.New()
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid key file pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return "", fmt.Errorf("no key file matches %s", pattern)
+		}
+
+		for _, match := range matches {
+			file, err := os.Open(match)
+			if err != nil {
+				return "", err
+			}
+			fileHash := sha256.New()
+			_, err = io.Copy(fileHash, file)
+			file.Close()
+			if err != nil {
+				return "", fmt.Errorf("failed to hash key file %s: %v", match, err)
+			}
+			keyHash.Write(fileHash.Sum(nil))
+		}
+	}
+
+	return hex.EncodeToString(keyHash.Sum(nil))[:keyHashLength], nil
+}
+
+// ResolveKey returns the path to cache and the key to store it under for a cache key template.
+// A template is a path followed by @ and a suffix, in which every {hash:<files>} is replaced by the hash
+// of the comma separated files, e.g. node_modules@{hash:package-lock.json} caches node_modules under
+// node_modules@<hash>. When keyFiles is not empty, their hash is added to the suffix.
+// A template without {hash:...} is a path, stored under itself when there are no keyFiles
+func ResolveKey(template string, keyFiles string) (string, string, error) {
+	path, suffix := template, ""
+	if loc := hashTemplate.FindStringIndex(template); loc != nil {
+		at := strings.LastIndex(template[:loc[0]], "@")
+		if at < 0 {
+			return "", "", fmt.Errorf("invalid cache key %s, expected <path>@{hash:<files>}", template)
+		}
+		path, suffix = template[:at], template[at+1:]
+	}
+
+	var err error
+	suffix = hashTemplate.ReplaceAllStringFunc(suffix, func(expr string) string {
+		var hash string
+		if err == nil {
+			hash, err = hashFiles(hashTemplate.FindStringSubmatch(expr)[1])
+		}
+		return hash
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if strings.TrimSpace(keyFiles) != "" {
+		hash, err := hashFiles(keyFiles)
+		if err != nil {
+			return "", "", err
+		}
+		if suffix != "" {
+			suffix += "-"
+		}
+		suffix += hash
+	}
+
+	if suffix == "" {
+		return path, path, nil
+	}
+
+	return path, strings.TrimRight(path, "/") + "@" + suffix, nil
+}
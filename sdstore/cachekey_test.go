@@ -0,0 +1,64 @@
+package sdstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveKey(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cachekey")
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	_ = os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	_ = ioutil.WriteFile("go.mod", []byte("module example"), 0644)
+	_ = ioutil.WriteFile("go.sum", []byte("sums"), 0644)
+	_ = os.MkdirAll(filepath.Join("web", "a"), 0755)
+	_ = ioutil.WriteFile(filepath.Join("web", "a", "package-lock.json"), []byte("lock"), 0644)
+
+	modHash, _ := hashFiles("go.mod")
+	bothHash, _ := hashFiles("go.mod,go.sum")
+	if len(modHash) != keyHashLength || modHash == bothHash {
+		t.Fatalf("Unexpected hashes %s, %s", modHash, bothHash)
+	}
+	if globHash, _ := hashFiles("go.*"); globHash != bothHash {
+		t.Errorf("Expected the glob to hash go.mod and go.sum, got %s, want %s", globHash, bothHash)
+	}
+
+	tests := []struct {
+		template string
+		keyFiles string
+		path     string
+		key      string
+	}{
+		{"node_modules", "", "node_modules", "node_modules"},
+		{"user@host/cache", "", "user@host/cache", "user@host/cache"},
+		{"vendor/@{hash:go.mod}", "", "vendor/", "vendor@" + modHash},
+		{"vendor@v2-{hash:go.mod,go.sum}", "", "vendor", "vendor@v2-" + bothHash},
+		{"vendor", "go.mod, go.sum", "vendor", "vendor@" + bothHash},
+		{"vendor@v2-{hash:go.mod}", "go.mod,go.sum", "vendor", "vendor@v2-" + modHash + "-" + bothHash},
+	}
+
+	for _, tt := range tests {
+		path, key, err := ResolveKey(tt.template, tt.keyFiles)
+		if err != nil {
+			t.Errorf("ResolveKey(%q, %q) failed: %v", tt.template, tt.keyFiles, err)
+			continue
+		}
+		if path != tt.path || key != tt.key {
+			t.Errorf("ResolveKey(%q, %q) = %s, %s, want %s, %s", tt.template, tt.keyFiles, path, key, tt.path, tt.key)
+		}
+	}
+
+	for _, template := range []string{"vendor@{hash:missing.lock}", "{hash:go.mod}"} {
+		if _, _, err := ResolveKey(template, ""); err == nil {
+			t.Errorf("Expected an error for %s", template)
+		}
+	}
+	if _, _, err := ResolveKey("vendor", "missing.lock"); err == nil {
+		t.Error("Expected an error for a missing key file")
+	}
+}
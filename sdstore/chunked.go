@@ -0,0 +1,281 @@
+package sdstore
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+const PartsManifestSuffix = ".parts.json"
+
+// default settings for chunked uploads, chunked uploads are disabled unless a threshold is set
+const DefaultChunkSizeInMB = 64
+const DefaultChunkConcurrency = 4
+
+// PartInfo describes one part of a file uploaded in parts
+type PartInfo struct {
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5"`
+}
+
+// PartsManifest lists the parts of a file uploaded in parts. It is uploaded last,
+// so a file is only visible once all of its parts are in the store
+type PartsManifest struct {
+	Size     int64      `json:"size"`
+	PartSize int64      `json:"partSize"`
+	Parts    []PartInfo `json:"parts"`
+}
+
+// getEnvInt returns the value of an integer environment variable, or defaultValue when it is not set or invalid
+func getEnvInt(name string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// getChunkSettings checks the SD_STORE_CHUNK_THRESHOLD_MB, SD_STORE_CHUNK_SIZE_MB and SD_STORE_CHUNK_CONCURRENCY
+// environment variables. It returns the size in bytes above which files are uploaded in parts (0 when disabled),
+// the size in bytes of each part and the number of parts uploaded in parallel
+func getChunkSettings() (int64, int64, int) {
+	threshold := getEnvInt("SD_STORE_CHUNK_THRESHOLD_MB", 0)
+	size := getEnvInt("SD_STORE_CHUNK_SIZE_MB", DefaultChunkSizeInMB)
+	if size == 0 {
+		size = DefaultChunkSizeInMB
+	}
+	concurrency := getEnvInt("SD_STORE_CHUNK_CONCURRENCY", DefaultChunkConcurrency)
+	if concurrency == 0 {
+		concurrency = DefaultChunkConcurrency
+	}
+
+	return threshold << (10 * 2), size << (10 * 2), int(concurrency)
+}
+
+// partURL returns the url of the given part of a file uploaded in parts
+func partURL(url string, number int) string {
+	return fmt.Sprintf("%s.part-%05d", url, number)
+}
+
+// md5Section returns the md5 of length bytes of file starting at offset
+func md5Section(file *os.File, offset, length int64) (string, error) {
+	md5hash := md5.New()
+	if _, err := io.Copy(md5hash, io.NewSectionReader(file, offset, length)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(md5hash.Sum(nil)), nil
+}
+
+// putParts uploads the file at filePath in parts, in parallel, then commits the parts by uploading their manifest.
+// Every part is retried on its own. A file previously uploaded in one piece at the same url is removed, and so are
+// the extra parts of a larger file previously uploaded in parts
+func (s *sdStore) putParts(u *url.URL, bodyType string, filePath string, size int64, useExpectHeader bool) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	previous, err := s.getPartsManifest(u.String())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Unable to get the parts manifest of %s, continuing: %v", u.String(), err)
+	}
+
+	partCount := int((size + s.chunkSize - 1) / s.chunkSize)
+	manifest := PartsManifest{Size: size, PartSize: s.chunkSize, Parts: make([]PartInfo, partCount)}
+	log.Printf("Uploading: %s in %d parts", formatBytes(size), partCount)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		uploadErr error
+	)
+	startTime := time.Now()
+	sem := make(chan struct{}, s.chunkConcurrency)
+	for i := 0; i < partCount; i++ {
+		sem <- struct{}{}
+
+		mu.Lock()
+		failed := uploadErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(number int, offset, length int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			sum, err := md5Section(file, offset, length)
+			if err == nil {
+				var partU *url.URL
+				partU, err = url.Parse(partURL(u.String(), number))
+				if err == nil {
+					err = s.putBody(partU, bodyType, length, func() (io.Reader, error) {
+						return io.NewSectionReader(file, offset, length), nil
+					}, useExpectHeader)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				uploadErr = multierr.Append(uploadErr, fmt.Errorf("part %d: %v", number, err))
+				return
+			}
+			manifest.Parts[number-1] = PartInfo{Number: number, Size: length, MD5: sum}
+		}(i+1, int64(i)*s.chunkSize, min(s.chunkSize, size-int64(i)*s.chunkSize))
+	}
+	wg.Wait()
+
+	if uploadErr != nil {
+		return fmt.Errorf("failed to upload %s in parts: %v", filePath, uploadErr)
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestU, err := url.Parse(u.String() + PartsManifestSuffix)
+	if err != nil {
+		return err
+	}
+	err = s.putBody(manifestU, "application/json", int64(len(manifestJSON)), func() (io.Reader, error) {
+		return bytes.NewReader(manifestJSON), nil
+	}, useExpectHeader)
+	if err != nil {
+		return fmt.Errorf("failed to commit the parts of %s: %v", filePath, err)
+	}
+
+	elapsed := time.Since(startTime)
+	log.Printf("Upload completed in %.2fs", elapsed.Seconds())
+
+	// a file uploaded in one piece would shadow the parts
+	if err := s.remove(u.String()); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Unable to remove %s, continuing: %v", u.String(), err)
+	}
+	if previous != nil {
+		for _, part := range previous.Parts {
+			if part.Number <= partCount {
+				continue
+			}
+			if err := s.remove(partURL(u.String(), part.Number)); err != nil && !errors.Is(err, ErrNotFound) {
+				log.Printf("Unable to remove part %d of %s, continuing: %v", part.Number, u.String(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getPartsManifest downloads the parts manifest of the file at url
+func (s *sdStore) getPartsManifest(url string) (*PartsManifest, error) {
+	body, err := s.request(url+PartsManifestSuffix, "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest PartsManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("unparsable parts manifest for %s: %v", url, err)
+	}
+
+	return &manifest, nil
+}
+
+// mergeParts replaces the parts and manifest of the files uploaded in parts by one item for each file
+func mergeParts(items []ItemInfo) []ItemInfo {
+	partRegexp := regexp.MustCompile(`^(.+)\.part-[0-9]+$`)
+
+	var merged []ItemInfo
+	files := map[string]int{}
+	for _, item := range items {
+		key := item.Key
+		if matched := partRegexp.FindStringSubmatch(key); matched != nil {
+			key = matched[1]
+		} else if strings.HasSuffix(key, PartsManifestSuffix) {
+			key = strings.TrimSuffix(key, PartsManifestSuffix)
+			item.Size = 0
+		} else {
+			merged = append(merged, item)
+			continue
+		}
+
+		i, ok := files[key]
+		if !ok {
+			files[key] = len(merged)
+			merged = append(merged, ItemInfo{Key: key, Size: item.Size, LastModified: item.LastModified})
+			continue
+		}
+		merged[i].Size += item.Size
+		if item.LastModified.After(merged[i].LastModified) {
+			merged[i].LastModified = item.LastModified
+		}
+	}
+
+	return merged
+}
+
+// fetchParts downloads the parts listed in manifest one after the other to w, checking the size and md5 of each part
+func (s *sdStore) fetchParts(url string, manifest *PartsManifest, w io.Writer) (int64, error) {
+	log.Printf("Downloading: %s in %d parts", formatBytes(manifest.Size), len(manifest.Parts))
+
+	var written int64
+	for _, part := range manifest.Parts {
+		md5hash := md5.New()
+		n, err := s.fetch(partURL(url, part.Number), w, md5hash)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		if n != part.Size || hex.EncodeToString(md5hash.Sum(nil)) != part.MD5 {
+			return written, fmt.Errorf("part %d of %s does not match its manifest", part.Number, url)
+		}
+	}
+
+	if written != manifest.Size {
+		return written, fmt.Errorf("downloaded %d bytes, expected %d", written, manifest.Size)
+	}
+
+	return written, nil
+}
+
+// removeParts removes the parts and manifest of the file at u. When the file was not uploaded
+// in parts, notFoundErr is returned
+func (s *sdStore) removeParts(u *url.URL, notFoundErr error) error {
+	manifest, err := s.getPartsManifest(u.String())
+	if errors.Is(err, ErrNotFound) {
+		return notFoundErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, part := range manifest.Parts {
+		if err := s.remove(partURL(u.String(), part.Number)); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	return s.remove(u.String() + PartsManifestSuffix)
+}
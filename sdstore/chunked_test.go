@@ -0,0 +1,258 @@
+package sdstore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// makeFakeObjectStoreHTTPClient returns a client for a fake store keeping the uploaded objects in memory
+func makeFakeObjectStoreHTTPClient(t *testing.T) (*http.Client, map[string][]byte) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validateHeader(t, "Authorization", "Bearer faketoken")(r)
+
+		mu.Lock()
+		defer mu.Unlock()
+		path := r.URL.EscapedPath()
+		switch r.Method {
+		case "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			objects[path] = body
+		case "GET", "HEAD":
+			body, ok := objects[path]
+			if !ok {
+				w.WriteHeader(404)
+				w.Write([]byte(`{"statusCode":404,"error":"Not Found","message":"Not Found"}`))
+				return
+			}
+			http.ServeContent(w, r, "", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), bytes.NewReader(body))
+		case "DELETE":
+			if _, ok := objects[path]; !ok {
+				w.WriteHeader(404)
+				w.Write([]byte(`{"statusCode":404,"error":"Not Found","message":"Not Found"}`))
+				return
+			}
+			delete(objects, path)
+			w.WriteHeader(204)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}}
+
+	return client, objects
+}
+
+func TestGetChunkSettings(t *testing.T) {
+	threshold, size, concurrency := getChunkSettings()
+	if threshold != 0 || size != DefaultChunkSizeInMB<<20 || concurrency != DefaultChunkConcurrency {
+		t.Errorf("unexpected default chunk settings %d, %d, %d", threshold, size, concurrency)
+	}
+
+	os.Setenv("SD_STORE_CHUNK_THRESHOLD_MB", "100")
+	os.Setenv("SD_STORE_CHUNK_SIZE_MB", "8")
+	os.Setenv("SD_STORE_CHUNK_CONCURRENCY", "invalid")
+	defer os.Unsetenv("SD_STORE_CHUNK_THRESHOLD_MB")
+	defer os.Unsetenv("SD_STORE_CHUNK_SIZE_MB")
+	defer os.Unsetenv("SD_STORE_CHUNK_CONCURRENCY")
+
+	threshold, size, concurrency = getChunkSettings()
+	if threshold != 100<<20 || size != 8<<20 || concurrency != DefaultChunkConcurrency {
+		t.Errorf("unexpected chunk settings %d, %d, %d", threshold, size, concurrency)
+	}
+}
+
+func TestUploadAndDownloadInParts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "chunked")
+	defer os.RemoveAll(dir)
+
+	want := make([]byte, 10*1024)
+	rand.Read(want)
+	filePath := filepath.Join(dir, "bigfile")
+	_ = ioutil.WriteFile(filePath, want, 0644)
+
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/" + url.PathEscape(filePath))
+	store := newStore(2)
+	store.chunkThreshold = 1024
+	store.chunkSize = 3 * 1024
+	store.chunkConcurrency = 2
+
+	client, objects := makeFakeObjectStoreHTTPClient(t)
+	store.client.HTTPClient = client
+
+	// a file uploaded in one piece before is replaced by the parts
+	objects[u.EscapedPath()] = []byte("stale")
+
+	err := store.upload(u, "text/plain", filePath, false)
+	if err != nil {
+		t.Fatalf("Expected nil from store.upload(), got %v", err)
+	}
+
+	if _, ok := objects[u.EscapedPath()]; ok {
+		t.Errorf("Expected the file uploaded in one piece to be removed")
+	}
+	if _, ok := objects[u.EscapedPath()+PartsManifestSuffix]; !ok {
+		t.Errorf("Expected the parts manifest to be uploaded")
+	}
+	parts := 0
+	for path := range objects {
+		if strings.Contains(path, ".part-") {
+			parts++
+		}
+	}
+	if parts != 4 {
+		t.Errorf("Expected 4 parts, got %d", parts)
+	}
+
+	_ = os.Remove(filePath)
+	err = store.Download(u, false)
+	if err != nil {
+		t.Fatalf("Expected nil from store.Download(), got %v", err)
+	}
+	got, _ := ioutil.ReadFile(filePath)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Downloaded content does not match the uploaded content")
+	}
+
+	err = store.Remove(u)
+	if err != nil {
+		t.Fatalf("Expected nil from store.Remove(), got %v", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("Expected all parts to be removed, got %d objects left", len(objects))
+	}
+}
+
+func TestUploadAgainInOnePieceOrFewerParts(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "bigfile")
+	_ = ioutil.WriteFile(filePath, bytes.Repeat([]byte("a"), 4096), 0644)
+
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/" + url.PathEscape(filePath))
+	store := newStore(0)
+	store.chunkThreshold = 1024
+	store.chunkSize = 1024
+	store.chunkConcurrency = 2
+
+	client, objects := makeFakeObjectStoreHTTPClient(t)
+	store.client.HTTPClient = client
+	countParts := func() int {
+		parts := 0
+		for path := range objects {
+			if strings.Contains(path, ".part-") {
+				parts++
+			}
+		}
+		return parts
+	}
+
+	if err := store.upload(u, "text/plain", filePath, false); err != nil {
+		t.Fatalf("Expected nil from store.upload(), got %v", err)
+	}
+	// a smaller file in fewer parts replaces the extra parts
+	_ = ioutil.WriteFile(filePath, bytes.Repeat([]byte("b"), 2048), 0644)
+	if err := store.upload(u, "text/plain", filePath, false); err != nil {
+		t.Fatalf("Expected nil from store.upload(), got %v", err)
+	}
+	if parts := countParts(); parts != 2 {
+		t.Errorf("Expected 2 parts, got %d", parts)
+	}
+
+	// a small file in one piece replaces the parts and their manifest
+	_ = ioutil.WriteFile(filePath, []byte("small"), 0644)
+	if err := store.upload(u, "text/plain", filePath, false); err != nil {
+		t.Fatalf("Expected nil from store.upload(), got %v", err)
+	}
+	if _, ok := objects[u.EscapedPath()+PartsManifestSuffix]; ok || countParts() != 0 {
+		t.Errorf("Expected the parts to be removed, got %d parts", countParts())
+	}
+
+	// parts left by an upload in parts are removed with the file
+	objects[u.EscapedPath()+PartsManifestSuffix] = []byte(`{"size":4,"partSize":4,"parts":[{"number":1,"size":4,"md5":""}]}`)
+	objects[partURL(u.EscapedPath(), 1)] = []byte("old!")
+	if err := store.Remove(u); err != nil {
+		t.Fatalf("Expected nil from store.Remove(), got %v", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("Expected everything to be removed, got %d objects left", len(objects))
+	}
+	if err := store.Download(u, false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a miss after the removal, got %v", err)
+	}
+}
+
+func TestDownloadCorruptedPart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "chunked")
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "bigfile")
+	_ = ioutil.WriteFile(filePath, bytes.Repeat([]byte("a"), 4096), 0644)
+
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/" + url.PathEscape(filePath))
+	store := newStore(0)
+	store.chunkThreshold = 1024
+	store.chunkSize = 1024
+	store.chunkConcurrency = 4
+
+	client, objects := makeFakeObjectStoreHTTPClient(t)
+	store.client.HTTPClient = client
+
+	if err := store.upload(u, "text/plain", filePath, false); err != nil {
+		t.Fatalf("Expected nil from store.upload(), got %v", err)
+	}
+	objects[partURL(u.EscapedPath(), 2)] = bytes.Repeat([]byte("b"), 1024)
+
+	_ = os.Remove(filePath)
+	err := store.Download(u, false)
+	if err == nil || !strings.Contains(err.Error(), "part 2") {
+		t.Errorf("Expected part 2 to be reported as corrupted, got %v", err)
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		t.Errorf("Expected no file to be written")
+	}
+}
+
+func TestStatInParts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "chunked")
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "bigfile")
+	_ = ioutil.WriteFile(filePath, bytes.Repeat([]byte("a"), 4096), 0644)
+
+	u, _ := url.Parse("http://fakestore.example.com/v1/builds/1234/ARTIFACTS/bigfile")
+	store := newStore(0)
+	store.chunkThreshold = 1024
+	store.chunkSize = 1024
+	store.chunkConcurrency = 4
+
+	client, _ := makeFakeObjectStoreHTTPClient(t)
+	store.client.HTTPClient = client
+
+	if err := store.upload(u, "text/plain", filePath, false); err != nil {
+		t.Fatalf("Expected nil from store.upload(), got %v", err)
+	}
+
+	info, err := store.Stat(u)
+	if err != nil {
+		t.Fatalf("Expected nil from store.Stat(), got %v", err)
+	}
+	if info.Size != 4096 {
+		t.Errorf("Expected size 4096, got %d", info.Size)
+	}
+}
@@ -0,0 +1,34 @@
+package sdstore
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// number of bytes http.DetectContentType considers
+const sniffLength = 512
+
+// detectContentType returns the MIME type of the file at filePath from its extension,
+// or from its first bytes when the extension is unknown
+func detectContentType(filePath string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		return contentType
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "text/plain"
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "text/plain"
+	}
+
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,33 @@
+package sdstore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"index.html", "<html></html>", "text/html"},
+		{"report.json", "{}", "application/json"},
+		{"logo.png", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"logo", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"page", "<!DOCTYPE html><html></html>", "text/html"},
+		{"build-log", "step 1 done", "text/plain"},
+		{"blob", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		filePath := filepath.Join(dir, tt.name)
+		_ = ioutil.WriteFile(filePath, []byte(tt.content), 0644)
+		if got := detectContentType(filePath); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("detectContentType(%s) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package sdstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DirectoryManifestSuffix is the suffix of the manifest uploaded with the files of a directory
+const DirectoryManifestSuffix = "_manifest.json"
+
+// default number of files of a directory uploaded in parallel
+const DefaultUploadConcurrency = 4
+
+// ManifestEntry describes one file uploaded with a directory
+type ManifestEntry struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	MD5         string `json:"md5"`
+	ContentType string `json:"contentType"`
+}
+
+// DirectoryManifest lists the files uploaded with a directory, by their slash separated path relative to it.
+// It is uploaded last, next to the files, as <directory>_manifest.json
+type DirectoryManifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// getUploadConcurrency checks the SD_STORE_UPLOAD_CONCURRENCY environment variable.
+// It returns the number of files of a directory uploaded in parallel
+func getUploadConcurrency() int {
+	concurrency := getEnvInt("SD_STORE_UPLOAD_CONCURRENCY", DefaultUploadConcurrency)
+	if concurrency == 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+
+	return int(concurrency)
+}
+
+// directoryFiles returns the regular files under dirPath but those excluded by ex,
+// by their slash separated path relative to it
+func directoryFiles(dirPath string, ex *excludes) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ex.skip(dirPath, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			log.Printf("Skipping %s, not a regular file", path)
+			return nil
+		}
+
+		rel, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+
+	return files, err
+}
+
+// uploadDirectory uploads every file under dirPath to u followed by its path relative to dirPath, in parallel,
+// then uploads the manifest of the files to u followed by DirectoryManifestSuffix. The files are uploaded
+// with contentType, or with the type detected for each of them when contentType is empty. Files excluded by ex are skipped
+func (s *sdStore) uploadDirectory(u *url.URL, contentType string, dirPath string, ex *excludes, useExpectHeader bool) error {
+	files, err := directoryFiles(dirPath, ex)
+	if err != nil {
+		return err
+	}
+
+	manifest := DirectoryManifest{Files: make([]ManifestEntry, len(files))}
+	log.Printf("Uploading: %d files of %s", len(files), dirPath)
+
+	concurrency := s.uploadConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		uploadErr error
+		total     int64
+	)
+	startTime := time.Now()
+	sem := make(chan struct{}, concurrency)
+	for i, file := range files {
+		sem <- struct{}{}
+
+		// once a file failed, the remaining files are not uploaded
+		mu.Lock()
+		failed := uploadErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			filePath := filepath.Join(dirPath, filepath.FromSlash(file))
+			info, err := os.Stat(filePath)
+			var sum string
+			if err == nil {
+				sum, err = hashFromPath(filePath)
+			}
+			bodyType := contentType
+			if bodyType == "" {
+				bodyType = detectContentType(filePath)
+			}
+			if err == nil {
+				var fileU *url.URL
+				fileU, err = url.Parse(u.String() + url.PathEscape("/"+file))
+				if err == nil {
+					err = s.upload(fileU, bodyType, filePath, useExpectHeader)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if uploadErr == nil {
+					uploadErr = fmt.Errorf("%s: %v", file, err)
+				}
+				return
+			}
+			manifest.Files[i] = ManifestEntry{Path: file, Size: info.Size(), MD5: sum, ContentType: bodyType}
+			total += info.Size()
+		}(i, file)
+	}
+	wg.Wait()
+
+	if uploadErr != nil {
+		return fmt.Errorf("failed to upload directory %s: %v", dirPath, uploadErr)
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestU, err := url.Parse(u.String() + DirectoryManifestSuffix)
+	if err != nil {
+		return err
+	}
+	err = s.putBody(manifestU, "application/json", int64(len(manifestJSON)), func() (io.Reader, error) {
+		return bytes.NewReader(manifestJSON), nil
+	}, useExpectHeader)
+	if err != nil {
+		return fmt.Errorf("failed to upload the manifest of %s: %v", dirPath, err)
+	}
+
+	log.Printf("Uploaded %d files of %s (upload size = %s) in %.2fs", len(files), dirPath, formatBytes(total), time.Since(startTime).Seconds())
+
+	return nil
+}
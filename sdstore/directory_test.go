@@ -0,0 +1,125 @@
+package sdstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUploadDirectory(t *testing.T) {
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	items := map[string][]byte{}
+	contentTypes := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxFlight {
+			maxFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		items[r.URL.EscapedPath()] = body
+		contentTypes[r.URL.EscapedPath()] = r.Header.Get("Content-Type")
+		inFlight--
+		mu.Unlock()
+		w.WriteHeader(202)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	reports := filepath.Join(dir, "reports")
+	files := map[string]string{"index.html": "<html>", "css/main.css": "body {}", "data/a.json": "{}", "data/b.json": "[]", "data/c.json": "1"}
+	for name, content := range files {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(reports, name)), 0755)
+		_ = ioutil.WriteFile(filepath.Join(reports, name), []byte(content), 0644)
+	}
+	_ = os.Symlink(filepath.Join(reports, "index.html"), filepath.Join(reports, "link.html"))
+
+	store := newStore(0)
+	store.uploadConcurrency = 2
+	u, _ := url.Parse(server.URL + "/v1/builds/1234/ARTIFACTS/reports")
+	if err := store.Upload(u, reports, false, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for name, content := range files {
+		path := "/v1/builds/1234/ARTIFACTS/" + url.PathEscape("reports/"+name)
+		if string(items[path]) != content {
+			t.Errorf("Expected %q at %s, got %q", content, path, items[path])
+		}
+	}
+	if maxFlight > 2 {
+		t.Errorf("Expected at most 2 uploads at a time, got %d", maxFlight)
+	}
+
+	var manifest DirectoryManifest
+	if err := json.Unmarshal(items["/v1/builds/1234/ARTIFACTS/reports_manifest.json"], &manifest); err != nil {
+		t.Fatalf("Unexpected manifest %q: %v", items["/v1/builds/1234/ARTIFACTS/reports_manifest.json"], err)
+	}
+	if len(manifest.Files) != len(files) {
+		t.Fatalf("Expected %d files in the manifest, got %v", len(files), manifest.Files)
+	}
+	for _, entry := range manifest.Files {
+		if int64(len(files[entry.Path])) != entry.Size || entry.MD5 == "" {
+			t.Errorf("Unexpected manifest entry %v", entry)
+		}
+		if path := "/v1/builds/1234/ARTIFACTS/" + url.PathEscape("reports/"+entry.Path); entry.ContentType == "" || contentTypes[path] != entry.ContentType {
+			t.Errorf("Uploaded %s as %s, manifest records %s", entry.Path, contentTypes[path], entry.ContentType)
+		}
+	}
+	if got := contentTypes["/v1/builds/1234/ARTIFACTS/"+url.PathEscape("reports/index.html")]; !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Uploaded index.html as %s, want text/html", got)
+	}
+	if len(items) != len(files)+1 {
+		t.Errorf("Expected the files and the manifest to be uploaded, got %d items", len(items))
+	}
+}
+
+func TestUploadDirectoryStopsOnError(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		puts  int
+		paths []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		puts++
+		paths = append(paths, r.URL.EscapedPath())
+		mu.Unlock()
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	reports := filepath.Join(t.TempDir(), "reports")
+	_ = os.MkdirAll(reports, 0755)
+	for i := 0; i < 20; i++ {
+		_ = ioutil.WriteFile(filepath.Join(reports, fmt.Sprintf("%02d.txt", i)), []byte("report"), 0644)
+	}
+
+	store := newStore(0)
+	store.uploadConcurrency = 2
+	u, _ := url.Parse(server.URL + "/v1/builds/1234/ARTIFACTS/reports")
+	err := store.Upload(u, reports, false, false)
+	if err == nil || strings.Contains(err.Error(), "; ") {
+		t.Errorf("Expected the error of the first file which failed, got %v", err)
+	}
+	// the uploads in flight when the first one failed may complete, no other one is started
+	if puts > 2 {
+		t.Errorf("Expected the upload to stop after the first error, got %d requests: %v", puts, paths)
+	}
+}
@@ -0,0 +1,61 @@
+package sdstore
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Plan is what get, set or remove would do with an item, resolved without changing anything
+type Plan struct {
+	// Location is the url of the item in the store, or the path of the cache with the disk strategy
+	Location string `json:"location"`
+	// Exists is true when the item is in the store and did not expire
+	Exists bool `json:"exists"`
+	// Files and Size are the number of files set would store and their size in bytes
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+	// Unchanged is true when set would skip the upload, the files being the same as those of the stored cache
+	Unchanged bool `json:"unchanged"`
+}
+
+// PlanArtifact resolves what get, set or remove would do with the artifact or log at u. filePath is the file
+// or directory set would upload, "" for get and remove
+func PlanArtifact(store SDStore, u *url.URL, filePath string, exclude []string) (*Plan, error) {
+	plan := &Plan{Location: u.String()}
+	_, err := store.Stat(u)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	plan.Exists = err == nil
+	if filePath == "" || filePath == "-" {
+		return plan, nil
+	}
+
+	ex, err := newExcludes(exclude)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		plan.Files, plan.Size = 1, info.Size()
+		return plan, nil
+	}
+
+	files, err := directoryFiles(filePath, ex)
+	if err != nil {
+		return nil, err
+	}
+	plan.Files = len(files)
+	for _, file := range files {
+		if info, err := os.Stat(filepath.Join(filePath, filepath.FromSlash(file))); err == nil {
+			plan.Size += info.Size()
+		}
+	}
+
+	return plan, nil
+}
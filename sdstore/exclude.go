@@ -0,0 +1,167 @@
+package sdstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// StoreIgnoreFile is the file exclude patterns are read from, in the current directory, one pattern per line
+const StoreIgnoreFile = ".storeignore"
+
+// excludeMarker holds the exclude patterns of a cache, one per line, in its md5 json,
+// so that the cache is verified without the files it was set without
+const excludeMarker = ".store-cli-exclude"
+
+// excludeRule is a compiled gitignore-style pattern
+type excludeRule struct {
+	// segments are the slash separated segments of the pattern, ** matches any number of them
+	segments []string
+	// negate re-includes the paths matching a pattern starting with !
+	negate bool
+	// dirOnly only matches directories, for a pattern ending with /
+	dirOnly bool
+	// anchored matches from the archived directory, for a pattern with a leading or middle /.
+	// Other patterns match a name at any depth
+	anchored bool
+}
+
+// excludes are the gitignore-style patterns files and directories are left out of archives and of their md5 with.
+// The patterns are relative to the archived directory. A nil *excludes excludes nothing
+type excludes struct {
+	patterns []string
+	rules    []excludeRule
+}
+
+// newExcludes compiles gitignore-style patterns, skipping blank lines and # comments. It returns nil without patterns
+func newExcludes(patterns []string) (*excludes, error) {
+	e := &excludes{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		var rule excludeRule
+		line := pattern
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			return nil, fmt.Errorf("invalid exclude pattern %s", pattern)
+		}
+
+		rule.segments = strings.Split(line, "/")
+		for _, segment := range rule.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern %s: %v", pattern, err)
+			}
+		}
+
+		e.patterns = append(e.patterns, pattern)
+		e.rules = append(e.rules, rule)
+	}
+
+	if len(e.rules) == 0 {
+		return nil, nil
+	}
+
+	return e, nil
+}
+
+// ReadStoreIgnore returns the lines of the exclude file at path, none when it does not exist
+func ReadStoreIgnore(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(string(b), "\n"), nil
+}
+
+// excluded returns true when the file or directory at the slash separated path rel, relative to the archived
+// directory, is excluded. As with gitignore, the last matching pattern wins
+func (e *excludes) excluded(rel string, isDir bool) bool {
+	if e == nil || rel == "." || rel == "" {
+		return false
+	}
+
+	segments := strings.Split(rel, "/")
+	excluded := false
+	for _, rule := range e.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.matches(segments) {
+			excluded = !rule.negate
+		}
+	}
+
+	return excluded
+}
+
+// skip returns true when filePath, a file or directory under the archived root, is excluded
+func (e *excludes) skip(root, filePath string, isDir bool) bool {
+	if e == nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return false
+	}
+
+	return e.excluded(filepath.ToSlash(rel), isDir)
+}
+
+// marker returns the exclude patterns as recorded under excludeMarker
+func (e *excludes) marker() string {
+	return strings.Join(e.patterns, "\n")
+}
+
+func (r excludeRule) matches(segments []string) bool {
+	if !r.anchored {
+		ok, _ := path.Match(r.segments[0], segments[len(segments)-1])
+		return ok
+	}
+
+	return matchSegments(r.segments, segments)
+}
+
+// matchSegments matches the segments of a path with the segments of a pattern, where ** matches any number of them
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+
+	return matchSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,138 @@
+package sdstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExcluded(t *testing.T) {
+	ex, err := newExcludes([]string{"# comment", "", "*.log", ".git/", "/build", "dist/**/*.map", "tmp", "!tmp/keep"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		rel      string
+		isDir    bool
+		expected bool
+	}{
+		{"debug.log", false, true},
+		{"a/b/debug.log", false, true},
+		{"debug.logs", false, false},
+		{".git", true, true},
+		{".git", false, false},
+		{"a/.git", true, true},
+		{"build", true, true},
+		{"a/build", true, false},
+		{"dist/app.js.map", false, true},
+		{"dist/js/app.js.map", false, true},
+		{"dist/app.js", false, false},
+		{"tmp", true, true},
+		{"tmp/keep", false, false},
+		{"src/main.go", false, false},
+		{".", true, false},
+	}
+	for _, tc := range testCases {
+		if actual := ex.excluded(tc.rel, tc.isDir); actual != tc.expected {
+			t.Errorf("Expected excluded(%s, %v) to be %v, got %v", tc.rel, tc.isDir, tc.expected, actual)
+		}
+	}
+
+	var none *excludes
+	if none.excluded("debug.log", false) {
+		t.Errorf("Expected nil excludes to exclude nothing")
+	}
+	if ex, _ = newExcludes([]string{" ", "# only comments"}); ex != nil {
+		t.Errorf("Expected nil excludes without patterns, got %v", ex)
+	}
+	for _, pattern := range []string{"/", "[a-"} {
+		if _, err = newExcludes([]string{pattern}); err == nil {
+			t.Errorf("Expected an error for pattern %s", pattern)
+		}
+	}
+}
+
+func TestReadStoreIgnore(t *testing.T) {
+	dir := t.TempDir()
+	patterns, err := ReadStoreIgnore(filepath.Join(dir, StoreIgnoreFile))
+	if err != nil || patterns != nil {
+		t.Errorf("Expected no patterns without %s, got %v, %v", StoreIgnoreFile, patterns, err)
+	}
+
+	_ = ioutil.WriteFile(filepath.Join(dir, StoreIgnoreFile), []byte("*.log\n.cache/\n"), 0644)
+	patterns, err = ReadStoreIgnore(filepath.Join(dir, StoreIgnoreFile))
+	if err != nil || strings.Join(patterns, ",") != "*.log,.cache/," {
+		t.Errorf("Unexpected patterns %v, %v", patterns, err)
+	}
+}
+
+func TestExcludeFromMetadataAndMD5(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "node_modules")
+	files := map[string]string{"a.js": "a", "debug.log": "log", ".cache/tmp": "tmp", "lib/b.js": "b"}
+	for name, content := range files {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		_ = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	ex, _ := newExcludes([]string{"*.log", ".cache/"})
+
+	fInfos, md5Before, _ := getMetadataInfo(dir, ex)
+	for _, f := range fInfos {
+		if strings.HasSuffix(f.Path, "debug.log") || strings.Contains(f.Path, ".cache") {
+			t.Errorf("Expected %s to be excluded", f.Path)
+		}
+	}
+
+	sums, err := md5All(dir, ex)
+	if err != nil || len(sums) != 2 {
+		t.Errorf("Expected the md5 of 2 files, got %v, %v", sums, err)
+	}
+
+	// excluded files do not change the metadata md5
+	_ = ioutil.WriteFile(filepath.Join(dir, "debug.log"), []byte("more log"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, ".cache", "other"), []byte("other"), 0644)
+	if _, md5After, _ := getMetadataInfo(dir, ex); md5After != md5Before {
+		t.Errorf("Expected the md5 to ignore excluded files")
+	}
+
+	uploaded, err := directoryFiles(dir, ex)
+	if err != nil || strings.Join(uploaded, ",") != "a.js,lib/b.js" {
+		t.Errorf("Unexpected directory files %v, %v", uploaded, err)
+	}
+}
+
+func TestCache2DiskExclude(t *testing.T) {
+	cacheDir := t.TempDir()
+	defer os.Setenv("SD_PIPELINE_CACHE_DIR", os.Getenv("SD_PIPELINE_CACHE_DIR"))
+	_ = os.Setenv("SD_PIPELINE_CACHE_DIR", cacheDir)
+
+	src := filepath.Join(t.TempDir(), "node_modules")
+	_ = os.MkdirAll(filepath.Join(src, ".cache"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(src, "a.js"), []byte("a"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(src, ".cache", "tmp"), []byte("tmp"), 0644)
+
+	if err := Cache2DiskWithOptions("set", "pipeline", src, CacheOptions{Exclude: []string{".cache/"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_ = os.RemoveAll(src)
+	if err := Cache2DiskWithOptions("get", "pipeline", src, CacheOptions{Strict: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(src, "a.js")); string(b) != "a" {
+		t.Errorf("Expected a.js to be restored, got %q", b)
+	}
+	var restored []string
+	_ = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if path != src {
+			rel, _ := filepath.Rel(src, path)
+			restored = append(restored, rel)
+		}
+		return nil
+	})
+	if strings.Join(restored, ",") != "a.js" {
+		t.Errorf("Expected only a.js to be restored, got %v", restored)
+	}
+}
@@ -0,0 +1,152 @@
+package sdstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetaExtension is the extension of the metadata file written next to the .md5 file of a cache in the shared file server
+const MetaExtension = ".meta"
+
+// expiryMarker is the key of the md5 json of a cache in the store recording when the cache expires
+const expiryMarker = ".store-cli-expires-at"
+
+// CacheMeta is the metadata recorded along with a cache
+type CacheMeta struct {
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Key is the key of a cache of the shared file server set from a relative path, which is stored at the same
+	// place as the absolute path. A cache without Key was set from an absolute path
+	Key string `json:"key,omitempty"`
+}
+
+// newCacheMeta returns the metadata of a cache set now, which expires after ttl unless ttl is 0
+func newCacheMeta(ttl time.Duration, now time.Time) CacheMeta {
+	var meta CacheMeta
+	if ttl > 0 {
+		expiresAt := now.Add(ttl).UTC().Truncate(time.Second)
+		meta.ExpiresAt = &expiresAt
+	}
+
+	return meta
+}
+
+// Expired returns true when the cache expired before now
+func (m CacheMeta) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// expiredError returns the error of an expired cache, which is a miss
+func expiredError(key string, meta CacheMeta) error {
+	return fmt.Errorf("cache %s expired at %s: %w", key, meta.ExpiresAt.Format(time.RFC3339), ErrNotFound)
+}
+
+// ParseTTL parses a time to live such as 12h, 7d or 2w. Besides the units of time.ParseDuration,
+// d stands for days and w for weeks
+func ParseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); strings.HasSuffix(s, suffix) && err == nil {
+			if n < 0 {
+				return 0, fmt.Errorf("invalid ttl %s, must not be negative", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %s, expected a duration such as 12h, 7d or 2w", s)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("invalid ttl %s, must not be negative", s)
+	}
+
+	return ttl, nil
+}
+
+// readCacheMeta reads the metadata file at path. A missing file is the metadata of a cache which never expires
+func readCacheMeta(path string) (CacheMeta, error) {
+	var meta CacheMeta
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return meta, fmt.Errorf("invalid cache metadata %s: %v", path, err)
+	}
+
+	return meta, nil
+}
+
+// writeCacheMeta writes meta to the metadata file at path, or removes the file when meta is empty
+func writeCacheMeta(path string, meta CacheMeta) error {
+	if meta == (CacheMeta{}) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, DefaultFilePermission)
+}
+
+// setExpiry records in the md5 json sums of a cache set at now when it expires, after ttl, unless ttl is 0
+func setExpiry(sums map[string]string, ttl time.Duration, now time.Time) {
+	if meta := newCacheMeta(ttl, now); meta.ExpiresAt != nil {
+		sums[expiryMarker] = meta.ExpiresAt.Format(time.RFC3339)
+	}
+}
+
+// md5Meta returns the metadata recorded in the md5 json sums of a cache
+func md5Meta(sums map[string]string) (CacheMeta, error) {
+	var meta CacheMeta
+	value, ok := sums[expiryMarker]
+	if !ok {
+		return meta, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return meta, fmt.Errorf("invalid expiry %q in md5 json: %v", value, err)
+	}
+	meta.ExpiresAt = &expiresAt
+
+	return meta, nil
+}
+
+// sameFiles returns true when the md5 jsons a and b list the same files, whatever expiry they record
+func sameFiles(a, b map[string]string) bool {
+	if len(a)-len(b) != countExpiry(a)-countExpiry(b) {
+		return false
+	}
+	for path, sum := range a {
+		if other, ok := b[path]; path != expiryMarker && (!ok || other != sum) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func countExpiry(sums map[string]string) int {
+	if _, ok := sums[expiryMarker]; ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,172 @@
+package sdstore
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/screwdriver-cd/store-cli/sdstore/sdstoretest"
+)
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		ttl  string
+		want time.Duration
+	}{
+		{"", 0},
+		{"90m", 90 * time.Minute},
+		{"12h", 12 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.ttl)
+		if err != nil {
+			t.Errorf("ParseTTL(%q) failed: %v", tt.ttl, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseTTL(%q) = %v, want %v", tt.ttl, got, tt.want)
+		}
+	}
+
+	for _, ttl := range []string{"7", "d", "-1d", "-5m", "seven days"} {
+		if _, err := ParseTTL(ttl); err == nil {
+			t.Errorf("Expected ParseTTL(%q) to fail", ttl)
+		}
+	}
+}
+
+func TestStoreBackendExpiry(t *testing.T) {
+	server := sdstoretest.NewServer("faketoken")
+	defer server.Close()
+	backend, _ := newRemoteBackend(BackendConfig{
+		Token:    "faketoken",
+		StoreURL: server.StoreURL(),
+		ScopeID:  func(scope string) string { return "1234" },
+	})
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "cache")
+	_ = os.MkdirAll(src, 0755)
+	_ = ioutil.WriteFile(filepath.Join(src, "file"), []byte("cached"), 0644)
+	wd, _ := os.Getwd()
+	_ = os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	if err := backend.Set("pipeline", src, src, SetOptions{TTL: 7 * 24 * time.Hour}); err != nil {
+		t.Fatalf("Unexpected error setting cache: %v", err)
+	}
+	md5Path := "caches/pipelines/1234/" + url.PathEscape(src+"_md5.json")
+	item, ok := server.Get(md5Path)
+	if !ok {
+		t.Fatalf("Expected the md5 json at %s, got %v", md5Path, server.Paths())
+	}
+	sums := make(map[string]string)
+	if err := json.Unmarshal(item.Body, &sums); err != nil || sums[expiryMarker] == "" {
+		t.Fatalf("Expected the expiry in the md5 json, got %s", item.Body)
+	}
+	if _, err := backend.Stat("pipeline", src); err != nil {
+		t.Fatalf("Expected an unexpired cache, got %v", err)
+	}
+	if err := backend.Verify("pipeline", src, src); err != nil {
+		t.Errorf("Expected the expiry not to be verified as a file, got %v", err)
+	}
+
+	// an expired cache is a miss
+	sums[expiryMarker] = "2020-01-01T00:00:00Z"
+	body, _ := json.Marshal(sums)
+	server.Put(md5Path, body)
+	if _, err := backend.Stat("pipeline", src); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an expired cache to be missing, got %v", err)
+	}
+	_ = os.RemoveAll(src)
+	if err := backend.Get("pipeline", src, src); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an expired cache not to be restored, got %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing restored at %s, got %v", src, err)
+	}
+
+	if err := backend.Remove("pipeline", src); err != nil {
+		t.Fatalf("Unexpected error removing cache: %v", err)
+	}
+	if paths := server.Paths(); len(paths) != 0 {
+		t.Errorf("Expected the cache and its md5 json to be removed, got %v", paths)
+	}
+}
+
+func TestStoreBackendWithoutTTL(t *testing.T) {
+	server := sdstoretest.NewServer("faketoken")
+	defer server.Close()
+	backend, _ := newRemoteBackend(BackendConfig{
+		Token:    "faketoken",
+		StoreURL: server.StoreURL(),
+		ScopeID:  func(scope string) string { return "1234" },
+	})
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "cache")
+	_ = os.MkdirAll(src, 0755)
+	_ = ioutil.WriteFile(filepath.Join(src, "file"), []byte("cached"), 0644)
+	wd, _ := os.Getwd()
+	_ = os.Chdir(dir)
+	defer os.Chdir(wd)
+	md5Path := "caches/pipelines/1234/" + url.PathEscape(src+"_md5.json")
+	archivePath := "caches/pipelines/1234/" + url.PathEscape(src+CompressFormatTarZst)
+	requests := func(method, path string) int {
+		count := 0
+		for _, request := range server.Requests() {
+			if strings.HasPrefix(request, method+" ") && strings.HasSuffix(request, path) {
+				count++
+			}
+		}
+		return count
+	}
+
+	// without a TTL, setting a cache only uploads it along with its md5 json
+	if err := backend.Set("pipeline", src, src, SetOptions{}); err != nil {
+		t.Fatalf("Unexpected error setting cache: %v", err)
+	}
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "HEAD") || strings.HasPrefix(request, "DELETE") {
+			t.Errorf("Unexpected request %s", request)
+		}
+	}
+
+	// setting it again with a TTL only uploads its md5 json again
+	if err := backend.Set("pipeline", src, src, SetOptions{TTL: time.Hour}); err != nil {
+		t.Fatalf("Unexpected error setting cache: %v", err)
+	}
+	if got := requests("PUT", md5Path); got != 2 {
+		t.Errorf("Expected the md5 json to be uploaded twice, got %d uploads", got)
+	}
+	if got := requests("PUT", archivePath); got != 1 {
+		t.Errorf("Expected the archive to be uploaded once, got %d uploads", got)
+	}
+
+	// the expiry of a cache set with a TTL before is removed
+	if err := backend.Set("pipeline", src, src, SetOptions{}); err != nil {
+		t.Fatalf("Unexpected error setting cache: %v", err)
+	}
+	if item, _ := server.Get(md5Path); strings.Contains(string(item.Body), expiryMarker) {
+		t.Errorf("Expected the expiry to be removed from the md5 json, got %s", item.Body)
+	}
+	if got := requests("PUT", archivePath); got != 1 {
+		t.Errorf("Expected the archive to be uploaded once, got %d uploads", got)
+	}
+
+	// an unchanged cache without a TTL is not uploaded again
+	if err := backend.Set("pipeline", src, src, SetOptions{}); err != nil {
+		t.Fatalf("Unexpected error setting cache: %v", err)
+	}
+	if got := requests("PUT", md5Path); got != 3 {
+		t.Errorf("Expected the md5 json to be uploaded 3 times, got %d uploads", got)
+	}
+}
@@ -0,0 +1,218 @@
+package sdstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileTransport serves the requests to the Store API made with file:// urls from a directory tree,
+// e.g. SD_STORE_URL=file:///tmp/store/ keeps the items in /tmp/store/caches/events/<id>/... and
+// /tmp/store/builds/<id>/ARTIFACTS/... Each segment of the escaped path of a url is a directory or file,
+// so the keys escaped in a single segment, like the paths of caches, keep their escaped slashes
+type fileTransport struct{}
+
+// filePathOf returns the path of the file a file:// url refers to. Segments are unescaped but for
+// their slashes and percent signs
+func filePathOf(u *url.URL) (string, error) {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = strings.NewReplacer("%", "%25", "/", "%2F").Replace(unescaped)
+	}
+
+	return filepath.FromSlash(strings.Join(segments, "/")), nil
+}
+
+// fileResponse returns a response to req
+func fileResponse(req *http.Request, statusCode int, header http.Header, body io.ReadCloser, contentLength int64) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	if body == nil {
+		body = http.NoBody
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: contentLength,
+		Request:       req,
+	}
+}
+
+// fileErrorResponse returns an error response to req with an SDError body, like the Store API does
+func fileErrorResponse(req *http.Request, statusCode int, err error) *http.Response {
+	body, _ := json.Marshal(SDError{StatusCode: statusCode, Reason: http.StatusText(statusCode), Message: err.Error()})
+	header := http.Header{"Content-Type": {"application/json"}}
+
+	return fileResponse(req, statusCode, header, ioutil.NopCloser(bytes.NewReader(body)), int64(len(body)))
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	path, err := filePathOf(req.URL)
+	if err != nil {
+		return fileErrorResponse(req, http.StatusBadRequest, err), nil
+	}
+
+	var res *http.Response
+	switch req.Method {
+	case "GET", "HEAD":
+		if strings.HasSuffix(req.URL.Path, "/") {
+			res, err = t.list(req, path)
+		} else {
+			res, err = t.get(req, path)
+		}
+	case "PUT":
+		res, err = t.put(req, path)
+	case "DELETE":
+		err = os.Remove(path)
+		res = fileResponse(req, http.StatusNoContent, nil, nil, 0)
+	default:
+		res = fileErrorResponse(req, http.StatusMethodNotAllowed, fmt.Errorf("%s is not supported", req.Method))
+	}
+
+	if os.IsNotExist(err) {
+		return fileErrorResponse(req, http.StatusNotFound, fmt.Errorf("%s not found", req.URL.String())), nil
+	}
+	if err != nil {
+		return fileErrorResponse(req, http.StatusInternalServerError, err), nil
+	}
+
+	return res, nil
+}
+
+// get serves the content of the file at path, from the offset of a "bytes=<offset>-" Range header
+func (t *fileTransport) get(req *http.Request, path string) (*http.Response, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+
+	header := http.Header{}
+	header.Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+
+	statusCode := http.StatusOK
+	var offset int64
+	if value := strings.TrimPrefix(req.Header.Get("Range"), "bytes="); strings.HasSuffix(value, "-") {
+		offset, err = strconv.ParseInt(strings.TrimSuffix(value, "-"), 10, 64)
+		if err == nil && offset > 0 && offset < fi.Size() {
+			statusCode = http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, fi.Size()-1, fi.Size()))
+		} else {
+			offset = 0
+		}
+	}
+
+	if req.Method == "HEAD" {
+		file.Close()
+		return fileResponse(req, statusCode, header, nil, fi.Size()-offset), nil
+	}
+
+	body := struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(file, offset, fi.Size()-offset), file}
+
+	return fileResponse(req, statusCode, header, body, fi.Size()-offset), nil
+}
+
+// list serves the files under the directory at path as a JSON array of ItemInfo, keyed by their unescaped path
+func (t *fileTransport) list(req *http.Request, dir string) (*http.Response, error) {
+	items := []ItemInfo{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key, err := url.PathUnescape(filepath.ToSlash(rel))
+		if err != nil {
+			key = filepath.ToSlash(rel)
+		}
+		items = append(items, ItemInfo{Key: key, Size: fi.Size(), LastModified: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if req.Method == "HEAD" {
+		return fileResponse(req, http.StatusOK, header, nil, int64(len(body))), nil
+	}
+
+	return fileResponse(req, http.StatusOK, header, ioutil.NopCloser(bytes.NewReader(body)), int64(len(body))), nil
+}
+
+// put writes the body of req to the file at path. The body is written to a temporary file first,
+// so an interrupted upload does not leave a truncated file behind
+func (t *fileTransport) put(req *http.Request, path string) (*http.Response, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.upload-%d", filepath.Base(path), time.Now().UnixNano()))
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil {
+		_, err = io.Copy(file, req.Body)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	return fileResponse(req, http.StatusOK, nil, nil, 0), nil
+}
@@ -0,0 +1,110 @@
+package sdstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilePathOf(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"file:///tmp/store/builds/1/ARTIFACTS/report.json", "/tmp/store/builds/1/ARTIFACTS/report.json"},
+		{"file:///tmp/my%20store/builds/1/ARTIFACTS/a%20b", "/tmp/my store/builds/1/ARTIFACTS/a b"},
+		{"file:///tmp/store/caches/events/1/%2Fhome%2Fnode_modules.tar.zst", "/tmp/store/caches/events/1/%2Fhome%2Fnode_modules.tar.zst"},
+		{"file:///tmp/store/builds/1/ARTIFACTS/100%25", "/tmp/store/builds/1/ARTIFACTS/100%25"},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		got, err := filePathOf(u)
+		if err != nil {
+			t.Errorf("filePathOf(%s) failed: %v", tt.url, err)
+		}
+		if got != tt.want {
+			t.Errorf("filePathOf(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "filestore")
+	defer os.RemoveAll(dir)
+	client := &http.Client{Transport: &http.Transport{}}
+	client.Transport.(*http.Transport).RegisterProtocol("file", &fileTransport{})
+	u := "file://" + dir + "/builds/1/ARTIFACTS/report.json"
+
+	res, err := client.Get(u)
+	if err != nil || res.StatusCode != 404 {
+		t.Fatalf("Expected a 404 response for a missing file, got %v, %v", res, err)
+	}
+
+	_ = os.MkdirAll(filepath.Join(dir, "builds", "1", "ARTIFACTS"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, "builds", "1", "ARTIFACTS", "report.json"), []byte("0123456789"), 0644)
+
+	req, _ := http.NewRequest("GET", u, nil)
+	req.Header.Set("Range", "bytes=4-")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 206 || string(body) != "456789" || res.Header.Get("Content-Range") != "bytes 4-9/10" {
+		t.Errorf("Unexpected range response %d %s %q", res.StatusCode, res.Header.Get("Content-Range"), body)
+	}
+}
+
+func TestFileStoreBackend(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "filestore")
+	defer os.RemoveAll(dir)
+	backend, _ := newRemoteBackend(BackendConfig{
+		StoreURL: "file://" + dir + "/",
+		ScopeID:  func(scope string) string { return "1234" },
+	})
+
+	src := filepath.Join(dir, "workspace", "cache")
+	_ = os.MkdirAll(src, 0755)
+	_ = ioutil.WriteFile(filepath.Join(src, "file"), []byte("cached"), 0644)
+
+	wd, _ := os.Getwd()
+	_ = os.Chdir(filepath.Join(dir, "workspace"))
+	defer os.Chdir(wd)
+
+	if err := backend.Set("pipeline", src, src, SetOptions{}); err != nil {
+		t.Fatalf("Unexpected error setting cache: %v", err)
+	}
+	archivePath := filepath.Join(dir, "caches", "pipelines", "1234", url.PathEscape(src+CompressFormatTarZst))
+	if _, err := os.Stat(archivePath); err != nil {
+		t.Fatalf("Expected archive at %s: %v", archivePath, err)
+	}
+
+	items, err := backend.List("pipeline")
+	if err != nil {
+		t.Fatalf("Unexpected error listing caches: %v", err)
+	}
+	if len(items) != 1 || items[0].Key != src {
+		t.Errorf("Unexpected cache items %v", items)
+	}
+
+	_ = os.RemoveAll(src)
+	if err := backend.Get("pipeline", src, src); err != nil {
+		t.Fatalf("Unexpected error getting cache: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(src, "file")); string(content) != "cached" {
+		t.Errorf("Restored file contains %q, want %q", content, "cached")
+	}
+
+	if err := backend.Remove("pipeline", src); err != nil {
+		t.Fatalf("Unexpected error removing cache: %v", err)
+	}
+	if _, err := backend.Stat("pipeline", src); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a removed cache to be missing, got %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 )
 
@@ -40,10 +41,10 @@ func hashFromPath(filePath string) (string, error) {
 }
 
 // sumFiles starts goroutines to walk the directory tree at root and digest each
-// regular file.  These goroutines send the results of the digests on the result
+// regular file which is not excluded.  These goroutines send the results of the digests on the result
 // channel and send the result of the walk on the error channel.  If done is
 // closed, sumFiles abandons its work.
-func sumFiles(done <-chan struct{}, root string) (<-chan result, <-chan error) {
+func sumFiles(done <-chan struct{}, root string, ex *excludes) (<-chan result, <-chan error) {
 	// For each regular file, start a goroutine that sums the file and sends
 	// the result on c.  Send the result of the walk on errc.
 	c := make(chan result)
@@ -54,6 +55,12 @@ func sumFiles(done <-chan struct{}, root string) (<-chan result, <-chan error) {
 			if err != nil {
 				return err
 			}
+			if ex.skip(root, path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 			if !info.Mode().IsRegular() {
 				return nil
 			}
@@ -91,12 +98,17 @@ func sumFiles(done <-chan struct{}, root string) (<-chan result, <-chan error) {
 // fails or any read operation fails, MD5All returns an error.  In that case,
 // MD5All does not wait for inflight read operations to complete.
 func MD5All(root string) (map[string]string, error) {
+	return md5All(root, nil)
+}
+
+// md5All is MD5All without the files and directories excluded by ex
+func md5All(root string, ex *excludes) (map[string]string, error) {
 	// MD5All closes the done channel when it returns; it may do so before
 	// receiving all the values from c and errc.
 	done := make(chan struct{})
 	defer close(done)
 
-	c, errc := sumFiles(done, root)
+	c, errc := sumFiles(done, root, ex)
 
 	m := make(map[string]string)
 	for r := range c {
@@ -110,3 +122,38 @@ func MD5All(root string) (map[string]string, error) {
 	}
 	return m, nil
 }
+
+// CompareMD5 compares the md5 sums of a file tree, as returned by MD5All, with the expected ones.
+// Paths are compared once cleaned. It returns the sorted paths of the missing files, of the
+// extra files and of the files whose contents differ
+func CompareMD5(expected, actual map[string]string) (missing, extra, corrupted []string) {
+	cleaned := make(map[string]string, len(actual))
+	for path, sum := range actual {
+		cleaned[filepath.Clean(path)] = sum
+	}
+
+	seen := make(map[string]bool, len(expected))
+	for path, sum := range expected {
+		path = filepath.Clean(path)
+		seen[path] = true
+
+		actualSum, ok := cleaned[path]
+		switch {
+		case !ok:
+			missing = append(missing, path)
+		case actualSum != sum:
+			corrupted = append(corrupted, path)
+		}
+	}
+
+	for path := range cleaned {
+		if !seen[path] {
+			extra = append(extra, path)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(extra)
+	sort.Strings(corrupted)
+	return missing, extra, corrupted
+}
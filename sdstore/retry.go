@@ -0,0 +1,166 @@
+package sdstore
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// backoffs between retries, selected with SD_STORE_RETRY_BACKOFF
+const (
+	LinearBackoff      = "linear"
+	ExponentialBackoff = "exponential"
+)
+
+// MaxRetryAfter is the longest wait requested by a Retry-After header honored without a max retry time
+const MaxRetryAfter = time.Minute
+
+// RetryPolicy is when and how long to wait before retrying a request to the store
+type RetryPolicy struct {
+	// Backoff is LinearBackoff, a random wait between the min and max waits times the attempt, or ExponentialBackoff,
+	// the min wait doubled at every attempt up to the max wait
+	Backoff string
+	// MaxTime is the time after which a failed request is no longer retried, 0 for no limit
+	MaxTime time.Duration
+	// StatusCodes are the status codes of the responses retried, 429 and 5xx but 501 when empty
+	StatusCodes []int
+}
+
+// retryStartKey is the context key of the time the first attempt of a request started
+type retryStartKey struct{}
+
+// getRetryPolicy checks the SD_STORE_RETRY_BACKOFF, SD_STORE_RETRY_MAX_TIME (seconds) and
+// SD_STORE_RETRY_STATUS_CODES (comma separated) environment variables. Invalid values are ignored
+func getRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{Backoff: LinearBackoff}
+
+	switch backoff := strings.ToLower(os.Getenv("SD_STORE_RETRY_BACKOFF")); backoff {
+	case "", LinearBackoff:
+	case ExponentialBackoff:
+		policy.Backoff = backoff
+	default:
+		log.Printf("Ignoring SD_STORE_RETRY_BACKOFF %q, expected %s or %s", backoff, LinearBackoff, ExponentialBackoff)
+	}
+	policy.MaxTime = time.Duration(getEnvInt("SD_STORE_RETRY_MAX_TIME", 0)) * time.Second
+
+	for _, code := range strings.Split(os.Getenv("SD_STORE_RETRY_STATUS_CODES"), ",") {
+		if code = strings.TrimSpace(code); code == "" {
+			continue
+		}
+		statusCode, err := strconv.Atoi(code)
+		if err != nil || statusCode < 100 || statusCode > 599 {
+			log.Printf("Ignoring status code %q of SD_STORE_RETRY_STATUS_CODES", code)
+			continue
+		}
+		policy.StatusCodes = append(policy.StatusCodes, statusCode)
+	}
+
+	return policy
+}
+
+// expired returns true when a request whose first attempt started at start is no longer retried
+func (p RetryPolicy) expired(start time.Time) bool {
+	return p.MaxTime > 0 && !start.IsZero() && time.Since(start) >= p.MaxTime
+}
+
+// checkRetry is the retryablehttp.CheckRetry of the policy. Errors are retried as with
+// retryablehttp.DefaultRetryPolicy, responses when their status code is one of StatusCodes
+func (p RetryPolicy) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if start, ok := ctx.Value(retryStartKey{}).(time.Time); ok && p.expired(start) {
+		log.Printf("Not retrying, the max retry time of %s elapsed", p.MaxTime)
+		return false, nil
+	}
+	if err != nil || resp == nil || len(p.StatusCodes) == 0 {
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+
+	for _, statusCode := range p.StatusCodes {
+		if resp.StatusCode == statusCode {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// backoff is the retryablehttp.Backoff of the policy. The Retry-After header of a 429 or 503 response
+// takes precedence, up to the time left before MaxTime, or up to MaxRetryAfter without MaxTime
+func (p RetryPolicy) backoff(min, max time.Duration, attempt int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp); ok {
+		limit := MaxRetryAfter
+		if p.MaxTime > 0 {
+			limit = p.remaining(resp)
+		}
+		if wait > limit {
+			wait = limit
+		}
+		log.Printf("Retrying after %s, as requested by the store", wait)
+		return wait
+	}
+
+	if p.Backoff == ExponentialBackoff {
+		return retryablehttp.DefaultBackoff(min, max, attempt, nil)
+	}
+
+	return retryablehttp.LinearJitterBackoff(min, max, attempt, nil)
+}
+
+// remaining returns the time left before MaxTime elapses since the first attempt of the request of resp,
+// MaxTime when the start of the request is unknown
+func (p RetryPolicy) remaining(resp *http.Response) time.Duration {
+	if resp.Request == nil {
+		return p.MaxTime
+	}
+	start, ok := resp.Request.Context().Value(retryStartKey{}).(time.Time)
+	if !ok {
+		return p.MaxTime
+	}
+	if left := p.MaxTime - time.Since(start); left > 0 {
+		return left
+	}
+
+	return 0
+}
+
+// retryAfter returns the wait of the Retry-After header of a 429 or 503 response, given in seconds or as a date.
+// A date in the past is ignored, the request is then retried after the backoff of the policy
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	header := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if wait := time.Until(date); wait > 0 {
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// withRetryStart returns ctx with the time the first attempt of its request starts, to stop retrying
+// the request after the max retry time
+func withRetryStart(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryStartKey{}, time.Now())
+}
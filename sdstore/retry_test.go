@@ -0,0 +1,159 @@
+package sdstore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setRetryEnv(t *testing.T, backoff, maxTime, statusCodes string) {
+	for name, value := range map[string]string{
+		"SD_STORE_RETRY_BACKOFF":      backoff,
+		"SD_STORE_RETRY_MAX_TIME":     maxTime,
+		"SD_STORE_RETRY_STATUS_CODES": statusCodes,
+	} {
+		os.Setenv(name, value)
+		t.Cleanup(func() { os.Unsetenv(name) })
+	}
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	testCases := []struct {
+		backoff, maxTime, statusCodes string
+		expected                      RetryPolicy
+	}{
+		{"", "", "", RetryPolicy{Backoff: LinearBackoff}},
+		{"Exponential", "30", "429, 503", RetryPolicy{Backoff: ExponentialBackoff, MaxTime: 30 * time.Second, StatusCodes: []int{429, 503}}},
+		{"fibonacci", "-1", "429,abc,42", RetryPolicy{Backoff: LinearBackoff, StatusCodes: []int{429}}},
+	}
+
+	for _, tc := range testCases {
+		setRetryEnv(t, tc.backoff, tc.maxTime, tc.statusCodes)
+		if policy := getRetryPolicy(); !reflect.DeepEqual(policy, tc.expected) {
+			t.Errorf("Expected %+v for %q, %q, %q, got %+v", tc.expected, tc.backoff, tc.maxTime, tc.statusCodes, policy)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	response := func(statusCode int, retryAfter string) *http.Response {
+		return &http.Response{StatusCode: statusCode, Header: http.Header{"Retry-After": []string{retryAfter}}}
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	// a response to a request whose first attempt started 30s ago
+	started := response(503, date)
+	started.Request = (&http.Request{}).WithContext(context.WithValue(context.Background(), retryStartKey{}, time.Now().Add(-30*time.Second)))
+
+	testCases := []struct {
+		policy   RetryPolicy
+		attempt  int
+		resp     *http.Response
+		min, max time.Duration
+	}{
+		{RetryPolicy{Backoff: ExponentialBackoff}, 0, nil, 100 * time.Millisecond, 100 * time.Millisecond},
+		{RetryPolicy{Backoff: ExponentialBackoff}, 2, nil, 400 * time.Millisecond, 400 * time.Millisecond},
+		{RetryPolicy{Backoff: ExponentialBackoff}, 10, nil, time.Second, time.Second},
+		{RetryPolicy{Backoff: LinearBackoff}, 1, nil, 200 * time.Millisecond, 2 * time.Second},
+		{RetryPolicy{Backoff: LinearBackoff}, 0, response(429, "5"), 5 * time.Second, 5 * time.Second},
+		// without a max retry time, the wait is capped at MaxRetryAfter
+		{RetryPolicy{Backoff: ExponentialBackoff}, 0, response(503, date), MaxRetryAfter, MaxRetryAfter},
+		{RetryPolicy{Backoff: ExponentialBackoff, MaxTime: 2 * time.Hour}, 0, response(503, date), 59 * time.Minute, time.Hour},
+		{RetryPolicy{Backoff: ExponentialBackoff, MaxTime: time.Minute}, 0, response(503, date), time.Minute, time.Minute},
+		// the wait is capped at the time left before the max retry time
+		{RetryPolicy{Backoff: ExponentialBackoff, MaxTime: time.Minute}, 0, started, 29 * time.Second, 30 * time.Second},
+		// a date in the past falls back to the backoff
+		{RetryPolicy{Backoff: ExponentialBackoff}, 1, response(503, past), 200 * time.Millisecond, 200 * time.Millisecond},
+		// Retry-After only applies to 429 and 503
+		{RetryPolicy{Backoff: ExponentialBackoff}, 0, response(500, "5"), 100 * time.Millisecond, 100 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		wait := tc.policy.backoff(100*time.Millisecond, time.Second, tc.attempt, tc.resp)
+		if wait < tc.min || wait > tc.max {
+			t.Errorf("Expected a wait between %s and %s for attempt %d of %+v, got %s", tc.min, tc.max, tc.attempt, tc.policy, wait)
+		}
+	}
+
+	// the default policy does not wait for a day
+	setRetryEnv(t, "", "", "")
+	if wait := getRetryPolicy().backoff(100*time.Millisecond, time.Second, 0, response(429, "86400")); wait != MaxRetryAfter {
+		t.Errorf("Expected the default policy to wait %s, got %s", MaxRetryAfter, wait)
+	}
+}
+
+func TestRetryPolicyWithServer(t *testing.T) {
+	var calls int32
+	statusCode := http.StatusTooManyRequests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(statusCode)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// a 429 is retried after its Retry-After
+	setRetryEnv(t, ExponentialBackoff, "", "")
+	store := NewStore("faketoken", 5, 1, 10, 20).(*sdStore)
+	if body, err := store.request(server.URL, "GET"); err != nil || string(body) != "ok" || calls != 3 {
+		t.Errorf("Expected the request to be retried twice, got %q, %v after %d calls", body, err, calls)
+	}
+
+	// only the configured status codes are retried
+	atomic.StoreInt32(&calls, 0)
+	statusCode = http.StatusInternalServerError
+	setRetryEnv(t, "", "", "429,503")
+	store = NewStore("faketoken", 5, 1, 10, 20).(*sdStore)
+	if _, err := store.request(server.URL, "GET"); err == nil || calls != 1 {
+		t.Errorf("Expected a 500 not to be retried, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryMaxTime(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	setRetryEnv(t, "", "1", "")
+	store := NewStore("faketoken", 100, 1, 300, 300).(*sdStore)
+	start := time.Now()
+	if _, err := store.request(server.URL, "GET"); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second || calls >= 100 {
+		t.Errorf("Expected the retries to stop after the max retry time, got %d calls in %s", calls, elapsed)
+	}
+}
+
+func TestRequestRetriesBodyRead(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := []byte(`{"key":"value"}`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the connection is closed before the whole body is sent
+			_, _ = w.Write(body[:5])
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	setRetryEnv(t, "", "", "")
+	store := NewStore("faketoken", 2, 1, 10, 20).(*sdStore)
+	if body, err := store.request(server.URL, "GET"); err != nil || string(body) != `{"key":"value"}` || calls != 2 {
+		t.Errorf("Expected the request to be sent again, got %q, %v after %d calls", body, err, calls)
+	}
+}
@@ -0,0 +1,279 @@
+package sdstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const s3Service = "s3"
+const s3Algorithm = "AWS4-HMAC-SHA256"
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// sha256 of an empty payload
+const s3EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config describes the S3-compatible object store the caches are kept in with SD_CACHE_STRATEGY=s3
+type S3Config struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// getEnvFirst returns the value of the first environment variable of names which is set
+func getEnvFirst(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// getS3Config checks the SD_S3_ENDPOINT, SD_S3_BUCKET, SD_S3_REGION, SD_S3_ACCESS_KEY_ID, SD_S3_SECRET_ACCESS_KEY
+// and SD_S3_SESSION_TOKEN environment variables. The region and credentials default to the AWS_* ones
+func getS3Config() S3Config {
+	region := getEnvFirst("SD_S3_REGION", "AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("SD_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	return S3Config{
+		Endpoint:        endpoint,
+		Bucket:          os.Getenv("SD_S3_BUCKET"),
+		Region:          region,
+		AccessKeyID:     getEnvFirst("SD_S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: getEnvFirst("SD_S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    getEnvFirst("SD_S3_SESSION_TOKEN", "AWS_SESSION_TOKEN"),
+	}
+}
+
+// bucketURL returns the path-style url of the bucket
+func (c S3Config) bucketURL() string {
+	return fmt.Sprintf("%s/%s/", strings.TrimRight(c.Endpoint, "/"), c.Bucket)
+}
+
+// s3Escape percent-encodes every byte of s but the unreserved characters, and slashes unless encodeSlash is set
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3CanonicalQuery returns the query of a request sorted and encoded as SigV4 expects
+func s3CanonicalQuery(query url.Values) string {
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Authorization returns the AWS Signature Version 4 Authorization header of req, which
+// must already have its X-Amz-Date and X-Amz-Content-Sha256 headers
+func s3Authorization(req *http.Request, config S3Config) string {
+	amzDate := req.Header.Get("X-Amz-Date")
+	date := amzDate
+	if len(date) > 8 {
+		date = date[:8]
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "content-md5" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	credentialScope := strings.Join([]string{date, config.Region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{s3Algorithm, amzDate, credentialScope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+config.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, config.Region)
+	signingKey = hmacSHA256(signingKey, s3Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return fmt.Sprintf("%s Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		s3Algorithm, config.AccessKeyID, credentialScope, signedHeaders, signature)
+}
+
+// signV4 signs req with AWS Signature Version 4 at time now. The payload of requests with a body is left unsigned
+func signV4(req *http.Request, config S3Config, now time.Time) {
+	payloadHash := s3EmptyPayload
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 {
+		payloadHash = s3UnsignedPayload
+	}
+
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", now.UTC().Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", config.SessionToken)
+	}
+	req.Header.Set("Authorization", s3Authorization(req, config))
+}
+
+// s3Transport signs the requests sent to an S3-compatible object store. The escaped path of the
+// requested url is used as the object key, so the objects keep the layout of the Store API
+type s3Transport struct {
+	config    S3Config
+	transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+
+	// S3 unescapes the path once to get the object key
+	key := req.URL.EscapedPath()
+	signed.URL.Path = key
+	signed.URL.RawPath = s3Escape(key, false)
+
+	signV4(signed, t.config, time.Now())
+
+	return t.transport.RoundTrip(signed)
+}
+
+// s3Store is an SDStore keeping the items in an S3-compatible object store
+type s3Store struct {
+	*sdStore
+	bucketURL string
+}
+
+// NewS3Store returns an SDStore instance for an S3-compatible object store. The urls passed to it
+// must be within the bucket, e.g. https://s3.us-west-2.amazonaws.com/<bucket>/caches/events/<id>/<key>
+func NewS3Store(config S3Config, maxRetries int, httpTimeout int, retryWaitMin int, retryWaitMax int) SDStore {
+	store := NewStore("", maxRetries, httpTimeout, retryWaitMin, retryWaitMax).(*sdStore)
+	store.client.HTTPClient.Transport = &s3Transport{config: config, transport: store.client.HTTPClient.Transport}
+
+	return &s3Store{sdStore: store, bucketURL: config.bucketURL()}
+}
+
+// s3ListResult is the response to a ListObjectsV2 request
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// s3Error is the body of an error response of S3
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// List the objects stored under a path within the bucket, with ListObjectsV2 requests.
+// The parts of a file uploaded in parts are listed as that file
+func (s *s3Store) List(u *url.URL) ([]ItemInfo, error) {
+	prefix := strings.TrimPrefix(u.String(), s.bucketURL)
+
+	var items []ItemInfo
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		body, err := s.request(strings.TrimSuffix(s.bucketURL, "/")+"?"+query.Encode(), "GET")
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("unparsable list response from S3: %v", err)
+		}
+		for _, object := range result.Contents {
+			key, err := url.PathUnescape(strings.TrimPrefix(object.Key, prefix))
+			if err != nil {
+				key = strings.TrimPrefix(object.Key, prefix)
+			}
+			items = append(items, ItemInfo{Key: key, Size: object.Size, LastModified: object.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		continuationToken = result.NextContinuationToken
+	}
+
+	return mergeParts(items), nil
+}
+
+// Remove an object from the bucket. S3 does not fail to remove missing objects,
+// so the object is checked first in case it was uploaded in parts
+func (s *s3Store) Remove(u *url.URL) error {
+	if _, err := s.head(u.String()); errors.Is(err, ErrNotFound) {
+		return s.removeParts(u, err)
+	}
+
+	return s.sdStore.Remove(u)
+}
@@ -2,7 +2,10 @@ package sdstore
 
 import (
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
@@ -10,7 +13,6 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -38,13 +40,38 @@ func formatBytes(bytes int64) string {
 // SDStore is able to upload, download, and remove the contents of a Reader to the SD Store
 type SDStore interface {
 	Upload(u *url.URL, filePath string, toCompress bool, useExpectHeader bool) error
+	UploadWithOptions(u *url.URL, filePath string, options UploadOptions) error
+	UploadFromReader(u *url.URL, r io.Reader, options UploadOptions) error
 	Download(url *url.URL, toExtract bool) error
+	DownloadTo(url *url.URL, filePath string, toExtract bool) error
+	DownloadToWriter(url *url.URL, w io.Writer) error
 	Remove(url *url.URL) error
+	List(url *url.URL) ([]ItemInfo, error)
+	Stat(url *url.URL) (*ItemInfo, error)
+	Verify(url *url.URL, filePath string) error
+}
+
+// ItemInfo describes an item in the store
+type ItemInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
 }
 
 type sdStore struct {
 	token  string
 	client *retryablehttp.Client
+
+	// files larger than chunkThreshold bytes are uploaded in parts of chunkSize bytes,
+	// chunkConcurrency parts at a time. A chunkThreshold of 0 disables chunked uploads
+	chunkThreshold   int64
+	chunkSize        int64
+	chunkConcurrency int
+
+	// number of files of a directory uploaded in parallel
+	uploadConcurrency int
+
+	retryPolicy RetryPolicy
 }
 
 // getExpectContinueTimeout checks the SD_EXPECT_CONTINUE_TIMEOUT environment variable.
@@ -71,23 +98,36 @@ func NewStore(token string, maxRetries int, httpTimeout int, retryWaitMin int, r
 	retryClient.RetryMax = maxRetries
 	retryClient.RetryWaitMin = time.Duration(retryWaitMin) * time.Millisecond
 	retryClient.RetryWaitMax = time.Duration(retryWaitMax) * time.Millisecond
-	retryClient.Backoff = retryablehttp.LinearJitterBackoff
+	retryPolicy := getRetryPolicy()
+	retryClient.Backoff = retryPolicy.backoff
 	retryClient.HTTPClient.Timeout = time.Duration(httpTimeout) * time.Second
-	retryClient.CheckRetry = retryablehttp.DefaultRetryPolicy
+	retryClient.CheckRetry = retryPolicy.checkRetry
 
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 
 	expectContinueTimeout := time.Duration(getExpectContinueTimeout())
 	customTransport.ExpectContinueTimeout = expectContinueTimeout * time.Second
+	// SD_STORE_URL=file:///some/dir/ keeps the items in a local directory tree
+	customTransport.RegisterProtocol("file", &fileTransport{})
 
 	retryClient.HTTPClient.Transport = customTransport
 
+	chunkThreshold, chunkSize, chunkConcurrency := getChunkSettings()
+
 	return &sdStore{
-		token:  token,
-		client: retryClient,
+		token:             token,
+		client:            retryClient,
+		chunkThreshold:    chunkThreshold,
+		chunkSize:         chunkSize,
+		chunkConcurrency:  chunkConcurrency,
+		uploadConcurrency: getUploadConcurrency(),
+		retryPolicy:       retryPolicy,
 	}
 }
 
+// ErrNotFound is returned when the requested item does not exist in the store
+var ErrNotFound = errors.New("not found in store")
+
 // SDError is an error response from the Screwdriver API
 type SDError struct {
 	StatusCode int    `json:"statusCode"`
@@ -97,7 +137,7 @@ type SDError struct {
 
 func getFilePath(u *url.URL) string {
 	path := u.Path
-	r, err := regexp.Compile("^/v[0-9]+/caches/(?:events|pipelines|jobs)/(?:[0-9]+)/(.+)$")
+	r, err := regexp.Compile("^.*?/caches/(?:events|pipelines|jobs)/(?:[0-9]+)/(.+)$")
 
 	if err != nil {
 		return ""
@@ -125,6 +165,10 @@ func (e SDError) Error() string {
 // Remove a file from a path within the SD Store
 func (s *sdStore) Remove(u *url.URL) error {
 	err := s.remove(u.String())
+	if err == nil || errors.Is(err, ErrNotFound) {
+		// the file may have been uploaded in parts, also before it was uploaded again in one piece
+		err = s.removeParts(u, err)
+	}
 	if err != nil {
 		return err
 	}
@@ -132,94 +176,201 @@ func (s *sdStore) Remove(u *url.URL) error {
 	return nil
 }
 
-// Download a file from a path within the SD Store
+// List the items stored under a path within the SD Store.
+// The parts of a file uploaded in parts are listed as that file
+func (s *sdStore) List(u *url.URL) ([]ItemInfo, error) {
+	body, err := s.request(u.String(), "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	var items []ItemInfo
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("unparsable list response from Store API: %v", err)
+	}
+
+	return mergeParts(items), nil
+}
+
+// Stat returns the size and last modified time of a file within the SD Store,
+// or an error wrapping ErrNotFound when the file does not exist
+func (s *sdStore) Stat(u *url.URL) (*ItemInfo, error) {
+	info, err := s.head(u.String())
+	if !errors.Is(err, ErrNotFound) {
+		return info, err
+	}
+
+	// the file may have been uploaded in parts
+	manifestInfo, manifestErr := s.head(u.String() + PartsManifestSuffix)
+	if errors.Is(manifestErr, ErrNotFound) {
+		return nil, err
+	}
+	if manifestErr != nil {
+		return nil, manifestErr
+	}
+	manifest, manifestErr := s.getPartsManifest(u.String())
+	if manifestErr != nil {
+		return nil, manifestErr
+	}
+
+	return &ItemInfo{Key: u.String(), Size: manifest.Size, LastModified: manifestInfo.LastModified}, nil
+}
+
+// CacheItems turns the files listed in a cache scope into the cache keys they store
+func CacheItems(items []ItemInfo) []ItemInfo {
+	var caches []ItemInfo
+	for _, item := range items {
+		for _, format := range []string{CompressFormatTarZst, CompressFormatZip} {
+			if strings.HasSuffix(item.Key, format) {
+				item.Key = strings.TrimSuffix(item.Key, format)
+				caches = append(caches, item)
+				break
+			}
+		}
+	}
+
+	return caches
+}
+
+// Download a file from a path within the SD Store. When toExtract is set, the .tar.zst archive
+// of the path is downloaded and extracted, falling back to the legacy .zip archive
 // Note: it's possible that this won't actually download a file and still return error == nil
 func (s *sdStore) Download(url *url.URL, toExtract bool) error {
-	urlString := url.String()
-	if toExtract {
-		urlString += ".zip"
-	}
+	return s.DownloadTo(url, getFilePath(url), toExtract)
+}
 
-	body, err := s.get(urlString)
-	if err != nil {
-		return err
+// DownloadTo downloads a file from a path within the SD Store to filePath. When toExtract is set, the archive
+// is written to filePath followed by its extension and extracted in the directory of filePath.
+// When filePath is empty, the file is downloaded but not written
+func (s *sdStore) DownloadTo(url *url.URL, filePath string, toExtract bool) error {
+	formats := []string{""}
+	if toExtract {
+		formats = []string{CompressFormatTarZst, CompressFormatZip}
 	}
 
 	// Read file
-	filePath := getFilePath(url)
 	log.Printf("filePath = %s", filePath)
-	if filePath != "" {
-		dir, _ := filepath.Split(filePath)
-		if !strings.HasPrefix(filePath, "/") {
-			wd, _ := os.Getwd()
-			dir = filepath.Join(wd, dir)
-		}
-		err = os.MkdirAll(dir, 0777)
-		if err != nil {
-			return err
+	if filePath == "" {
+		for i, format := range formats {
+			_, err := s.downloadContent(url.String()+format, ioutil.Discard)
+			if errors.Is(err, ErrNotFound) && i < len(formats)-1 {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			break
 		}
+		log.Printf("Request for %s successful, but not written to file.", url.String())
+		return nil
+	}
 
-		if toExtract {
-			filePath += ".zip"
-		}
-		file, err := os.Create(filePath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+	dir, _ := filepath.Split(filePath)
+	if !strings.HasPrefix(filePath, "/") {
+		wd, _ := os.Getwd()
+		dir = filepath.Join(wd, dir)
+	}
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
 
-		_, err = file.Write(body)
-		if err != nil {
-			return err
+	for i, format := range formats {
+		archivePath := filePath + format
+		err = s.downloadToFile(url.String()+format, archivePath)
+		if errors.Is(err, ErrNotFound) && i < len(formats)-1 {
+			log.Printf("No %s archive for %s, trying %s", format, url.String(), formats[i+1])
+			continue
 		}
-
-		// ensure file is flushed
-		err = file.Sync()
 		if err != nil {
 			return err
 		}
 
-		if toExtract {
-			_, err = Unzip(filePath, dir)
-			if err != nil {
-				log.Printf("Could not unzip file %s: %s", filePath, err)
-			} else {
-				os.Remove(filePath)
+		switch format {
+		case CompressFormatTarZst:
+			if err = Decompress(archivePath, dir); err != nil {
+				return fmt.Errorf("could not extract file %s: %w", archivePath, err)
 			}
+			os.Remove(archivePath)
+		case CompressFormatZip:
+			if _, err = Unzip(archivePath, dir); err != nil {
+				return fmt.Errorf("could not unzip file %s: %w", archivePath, err)
+			}
+			os.Remove(archivePath)
 		}
 
-		log.Printf("Download from %s to %s successful.", url.String(), filePath)
-	} else {
-		log.Printf("Request for %s successful, but not written to file.", url.String())
+		log.Printf("Download from %s to %s successful.", url.String(), archivePath)
+		break
 	}
 
 	return nil
 }
 
-func (s *sdStore) GenerateAndCheckMd5Json(url *url.URL, path string) (string, error) {
-	newMd5, err := MD5All(path)
+// DownloadToWriter streams a file from a path within the SD Store to w, e.g. os.Stdout
+func (s *sdStore) DownloadToWriter(url *url.URL, w io.Writer) error {
+	written, err := s.downloadContent(url.String(), w)
 	if err != nil {
-		return "", err
+		return err
 	}
+	log.Printf("Download from %s successful (download size = %s).", url.String(), formatBytes(written))
 
-	err = s.Download(url, false)
-	if err == nil {
-		oldMd5FilePath := fmt.Sprintf("%s_md5.json", filepath.Clean(path))
-		oldMd5File, err := ioutil.ReadFile(oldMd5FilePath)
-		if err != nil {
-			return "", err
-		}
+	return nil
+}
 
-		oldMd5 := make(map[string]string)
-		err = json.Unmarshal(oldMd5File, &oldMd5)
-		os.RemoveAll(oldMd5FilePath)
-		if err != nil {
-			return "", err
-		}
+// UploadFromReader sends the content of r, e.g. os.Stdin, to a path within the SD Store. The content is spooled
+// to a temporary file first, so that a failed request can be retried and a large content uploaded in parts.
+// Its content type is detected from the extension of the path and the content when not set in options
+func (s *sdStore) UploadFromReader(u *url.URL, r io.Reader, options UploadOptions) error {
+	if options.Compress {
+		return fmt.Errorf("unable to compress a stream, only files and directories can be cached")
+	}
 
-		if reflect.DeepEqual(oldMd5, newMd5) {
-			return "", fmt.Errorf("Contents unchanged")
-		}
+	spool, err := ioutil.TempFile("", "store-cli-spool-*"+filepath.Ext(u.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+
+	written, err := io.Copy(spool, r)
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to spool the content to upload to %s: %v", spool.Name(), err)
+	}
+	log.Printf("Spooled %s to upload to %s", formatBytes(written), u.String())
+
+	return s.UploadWithOptions(u, spool.Name(), options)
+}
+
+// cacheMD5 returns the md5 json of the cache of path, mapping each file to the md5 of its contents
+func cacheMD5(path string, ex *excludes) (map[string]string, error) {
+	sums, err := md5All(path, ex)
+	if err != nil {
+		return nil, err
+	}
+	if ex != nil {
+		sums[excludeMarker] = ex.marker()
+	}
+
+	return sums, nil
+}
+
+// errExpiryChanged is returned along with the md5 json of a cache whose files are unchanged but whose
+// expiry changed, so that only the md5 json is uploaded again
+var errExpiryChanged = errors.New("Expiry changed")
+
+func (s *sdStore) GenerateAndCheckMd5Json(url *url.URL, path string, ex *excludes, ttl time.Duration) (string, error) {
+	newMd5, err := cacheMD5(path, ex)
+	if err != nil {
+		return "", err
+	}
+	setExpiry(newMd5, ttl, time.Now())
+
+	oldMd5, err := s.getMd5Json(url)
+	unchanged := err == nil && sameFiles(oldMd5, newMd5)
+	if unchanged && oldMd5[expiryMarker] == newMd5[expiryMarker] {
+		return "", fmt.Errorf("Contents unchanged")
 	}
 
 	jsonString, err := json.Marshal(newMd5)
@@ -236,14 +387,120 @@ func (s *sdStore) GenerateAndCheckMd5Json(url *url.URL, path string) (string, er
 
 	jsonFile.Write(jsonString)
 
+	if unchanged {
+		return md5Path, errExpiryChanged
+	}
 	return md5Path, nil
 }
 
+// getMd5Json downloads the md5 json of a cache, mapping the path of each file to the md5 of its contents
+func (s *sdStore) getMd5Json(url *url.URL) (map[string]string, error) {
+	body, err := s.request(url.String(), "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	md5Json := make(map[string]string)
+	if err := json.Unmarshal(body, &md5Json); err != nil {
+		return nil, fmt.Errorf("unparsable md5 json from %s: %v", url.String(), err)
+	}
+
+	return md5Json, nil
+}
+
+// Verify checks the files at filePath against the md5 json uploaded with the cache at url.
+// Missing, extra and corrupted files are logged and make Verify return an error
+func (s *sdStore) Verify(u *url.URL, filePath string) error {
+	md5URL, err := url.Parse(fmt.Sprintf("%s%s", u.String(), "_md5.json"))
+	if err != nil {
+		return err
+	}
+
+	expected, err := s.getMd5Json(md5URL)
+	if err != nil {
+		return fmt.Errorf("failed to get md5 json to verify %s: %w", filePath, err)
+	}
+
+	// the expiry is not a file
+	delete(expected, expiryMarker)
+
+	// the files excluded when setting the cache are not verified
+	ex, err := newExcludes(strings.Split(expected[excludeMarker], "\n"))
+	if err != nil {
+		return err
+	}
+
+	var actual map[string]string
+	if patterns, ok := expected[bundleMarker]; ok {
+		// the files of a bundle are extracted to the paths matching its patterns, not to filePath
+		var entries []bundleEntry
+		if entries, err = expandBundle(strings.Split(patterns, "\n")); err == nil {
+			actual, err = bundleMD5(strings.Split(patterns, "\n"), entries, ex)
+		}
+	} else if actual, err = md5All(filePath, ex); err == nil && ex != nil {
+		actual[excludeMarker] = ex.marker()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to compute md5 to verify %s: %v", filePath, err)
+	}
+
+	missing, extra, corrupted := CompareMD5(expected, actual)
+	for _, path := range missing {
+		log.Printf("Verify: missing file %s", path)
+	}
+	for _, path := range extra {
+		log.Printf("Verify: extra file %s", path)
+	}
+	for _, path := range corrupted {
+		log.Printf("Verify: corrupted file %s", path)
+	}
+
+	if len(missing)+len(extra)+len(corrupted) > 0 {
+		return fmt.Errorf("verification of %s failed: %d missing, %d extra, %d corrupted files", filePath, len(missing), len(extra), len(corrupted))
+	}
+
+	log.Printf("Verification of %s successful (%d files).", filePath, len(actual))
+	return nil
+}
+
+// UploadOptions holds the settings of an upload
+type UploadOptions struct {
+	// Compress uploads a cache: the file or directory is compressed and the md5 of its files is uploaded with it
+	Compress        bool
+	UseExpectHeader bool
+	// ContentType is the type of the uploaded files, detected from their extension and content when empty
+	ContentType string
+	// Paths are bundled in one archive instead of the file or directory when compressing, see IsBundle
+	Paths []string
+	// Exclude are gitignore-style patterns of the files and directories left out of the upload
+	Exclude []string
+	// TTL is the time after which a compressed upload expires, 0 meaning it never expires
+	TTL time.Duration
+}
+
 // Uploads sends a file to a path within the SD Store. The path is relative to
 // the build/event path within the SD Store, e.g. http://store.screwdriver.cd/builds/abc/<storePath>
 func (s *sdStore) Upload(u *url.URL, filePath string, toCompress bool, useExpectHeader bool) error {
-	if !toCompress {
-		err := s.putFile(u, "text/plain", filePath, useExpectHeader)
+	return s.UploadWithOptions(u, filePath, UploadOptions{Compress: toCompress, UseExpectHeader: useExpectHeader})
+}
+
+// UploadWithOptions sends a file or a directory to a path within the SD Store with the given options
+func (s *sdStore) UploadWithOptions(u *url.URL, filePath string, options UploadOptions) error {
+	useExpectHeader := options.UseExpectHeader
+	ex, err := newExcludes(options.Exclude)
+	if err != nil {
+		return err
+	}
+	if !options.Compress {
+		if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+			return s.uploadDirectory(u, options.ContentType, filePath, ex, useExpectHeader)
+		}
+
+		contentType := options.ContentType
+		if contentType == "" {
+			contentType = detectContentType(filePath)
+		}
+		err := s.upload(u, contentType, filePath, useExpectHeader)
 		if err != nil {
 			log.Printf("failed to upload files %v to store (upload size = %s)", filePath, fileSize(filePath))
 			return err
@@ -251,18 +508,22 @@ func (s *sdStore) Upload(u *url.URL, filePath string, toCompress bool, useExpect
 		log.Printf("Upload to %s successful (upload size = %s).", u.String(), fileSize(filePath))
 		return nil
 	}
+	if len(options.Paths) > 0 {
+		return s.uploadBundle(u, options.Paths, ex, options.TTL, useExpectHeader)
+	}
 
 	fileName := filepath.Base(filePath)
 	encodedURL, err := url.Parse(fmt.Sprintf("%s%s", u.String(), "_md5.json"))
 	if err != nil {
 		return err
 	}
-	md5Json, err := s.GenerateAndCheckMd5Json(encodedURL, filePath)
+	md5Json, err := s.GenerateAndCheckMd5Json(encodedURL, filePath, ex, options.TTL)
 	if err != nil && err.Error() == "Contents unchanged" {
 		log.Printf("No change to %s, aborting upload", filePath)
 		return nil
 	}
-	if err != nil {
+	expiryOnly := errors.Is(err, errExpiryChanged)
+	if err != nil && !expiryOnly {
 		log.Printf("failed to generating md5 at %s", filePath)
 		return err
 	}
@@ -277,8 +538,12 @@ func (s *sdStore) Upload(u *url.URL, filePath string, toCompress bool, useExpect
 	if err != nil {
 		log.Printf("Unable to remove md5 file from path: %s, continuing", md5Json)
 	}
+	if expiryOnly {
+		log.Printf("No change to %s, only its expiry was updated", filePath)
+		return nil
+	}
 
-	zipPath, err := filepath.Abs(fmt.Sprintf("%s.zip", fileName))
+	archivePath, err := filepath.Abs(fmt.Sprintf("%s%s", fileName, CompressFormatTarZst))
 	if err != nil {
 		return err
 	}
@@ -287,27 +552,29 @@ func (s *sdStore) Upload(u *url.URL, filePath string, toCompress bool, useExpect
 	if err != nil {
 		return err
 	}
-	err = Zip(absPath, zipPath)
+	// the archive holds the path itself, so that it is extracted next to where it was
+	fInfos, _, _ := getMetadataInfo(absPath, ex)
+	err = Compress(filepath.Dir(absPath), archivePath, fInfos)
 	if err != nil {
-		log.Printf("failed to zip files from %v to %v", absPath, zipPath)
+		log.Printf("failed to compress files from %v to %v", absPath, archivePath)
 		return err
 	}
 	defer func() {
-		if err := os.Remove(zipPath); err != nil {
-			log.Printf("Unable to remove zip file: %v", err)
+		if err := os.Remove(archivePath); err != nil {
+			log.Printf("Unable to remove archive file: %v", err)
 		}
 	}()
 
-	encodedURL, err = url.Parse(fmt.Sprintf("%s%s", u.String(), ".zip"))
+	encodedURL, err = url.Parse(fmt.Sprintf("%s%s", u.String(), CompressFormatTarZst))
 	if err != nil {
 		return err
 	}
-	err = s.putFile(encodedURL, "text/plain", zipPath, useExpectHeader)
+	err = s.upload(encodedURL, "text/plain", archivePath, useExpectHeader)
 	if err != nil {
-		log.Printf("failed to upload file %s to store (upload size = %s)", zipPath, fileSize(zipPath))
+		log.Printf("failed to upload file %s to store (upload size = %s)", archivePath, fileSize(archivePath))
 		return err
 	}
-	log.Printf("Upload to %s successful (upload size = %s).", u.String(), fileSize(zipPath))
+	log.Printf("Upload to %s successful (upload size = %s).", u.String(), fileSize(archivePath))
 
 	return nil
 }
@@ -337,73 +604,430 @@ func (s *sdStore) remove(url string) error {
 	return err
 }
 
-// GET request; caller should close response.Body
-func (s *sdStore) get(url string) ([]byte, error) {
-	return s.request(url, "GET")
+// HEAD request
+func (s *sdStore) head(url string) (*ItemInfo, error) {
+	defer s.client.HTTPClient.CloseIdleConnections()
+
+	res, err := s.do(url, "HEAD", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	info := &ItemInfo{Key: url, Size: res.ContentLength}
+	if lastModified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = lastModified
+	}
+
+	return info, nil
 }
 
-func (s *sdStore) request(url string, requestType string) ([]byte, error) {
-	req, err := http.NewRequest(requestType, url, nil)
+// progressInterval is how often the progress of a download is logged
+var progressInterval = 10 * time.Second
+
+// progressWriter counts the bytes written through it, fails once more than
+// the expected size is written and periodically logs the progress
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	expected int64
+	lastLog  time.Time
+	err      error
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if p.expected >= 0 && p.written+int64(len(b)) > p.expected {
+		p.err = fmt.Errorf("received more than the expected %d bytes", p.expected)
+		return 0, p.err
+	}
+
+	n, err := p.w.Write(b)
+	p.written += int64(n)
 	if err != nil {
-		return nil, fmt.Errorf("Generating request to Screwdriver: %v", err)
+		p.err = err
+		return n, err
 	}
 
-	defer s.client.HTTPClient.CloseIdleConnections()
+	if time.Since(p.lastLog) >= progressInterval {
+		p.lastLog = time.Now()
+		if p.expected > 0 {
+			log.Printf("Downloaded %s of %s (%.0f%%)", formatBytes(p.written), formatBytes(p.expected), float64(p.written)*100/float64(p.expected))
+		} else {
+			log.Printf("Downloaded %s", formatBytes(p.written))
+		}
+	}
 
-	req.Header.Set("Authorization", tokenHeader(s.token))
+	return n, nil
+}
 
-	res, err := s.client.StandardClient().Do(req)
+// interruptedError is returned when the connection breaks before the whole body was received,
+// the download can then be resumed from where it stopped
+type interruptedError struct {
+	err error
+}
 
-	if res != nil {
-		defer res.Body.Close()
+func (e *interruptedError) Error() string {
+	return e.err.Error()
+}
+
+// parseContentRange parses a Content-Range header like "bytes 100-199/200" and returns
+// the first byte position and the complete length (-1 if unknown)
+func parseContentRange(contentRange string) (int64, int64, error) {
+	var start, end int64
+	var total string
+	_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q: %v", contentRange, err)
+	}
+
+	if total == "*" {
+		return start, -1, nil
 	}
 
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || end >= size {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	return start, size, nil
+}
+
+// download streams the body of a GET request to w. When offset is greater than 0, only the bytes after offset
+// are requested; if the Store API ignores the range, reset is called before the full body is written to w.
+// It returns the number of bytes written and the complete length of the content (-1 if unknown)
+func (s *sdStore) download(url string, w io.Writer, offset int64, reset func() error) (int64, int64, error) {
+	defer s.client.HTTPClient.CloseIdleConnections()
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := s.do(url, "GET", header)
 	if err != nil {
-		log.Printf("WARNING: received error from %s(%s): %v ", requestType, url, err)
-		return nil, fmt.Errorf("WARNING: received error from %s(%s): %v ", requestType, url, err)
+		return 0, -1, err
 	}
+	defer res.Body.Close()
 
-	// For GET requests, display file size and estimated download time
-	if requestType == "GET" && res.StatusCode/100 == 2 {
-		if contentLength := res.ContentLength; contentLength > 0 {
-			log.Printf("Downloading: %s ", formatBytes(contentLength))
+	contentLength := res.ContentLength
+	total := contentLength
+	if offset > 0 {
+		if res.StatusCode == http.StatusPartialContent {
+			start, size, err := parseContentRange(res.Header.Get("Content-Range"))
+			if err != nil {
+				return 0, -1, err
+			}
+			if start != offset {
+				return 0, -1, fmt.Errorf("requested content from byte %d, but received it from byte %d", offset, start)
+			}
+			total = size
+			log.Printf("Resuming download at %s", formatBytes(offset))
+		} else {
+			log.Printf("Range request ignored by Store API, downloading %s from the beginning", url)
+			if err := reset(); err != nil {
+				return 0, -1, err
+			}
 		}
 	}
 
+	// Display file size and estimated download time
+	if contentLength > 0 {
+		log.Printf("Downloading: %s ", formatBytes(contentLength))
+	}
+
 	startTime := time.Now()
-	body, err := ioutil.ReadAll(res.Body)
+	pw := &progressWriter{w: w, expected: contentLength, lastLog: startTime}
+	written, err := io.Copy(pw, res.Body)
 	if err != nil {
 		log.Printf("reading response Body from Store API: %v", err)
-		return nil, fmt.Errorf("reading response Body from Store API: %v", err)
+		err = fmt.Errorf("reading response Body from Store API: %v", err)
+		if pw.err != nil {
+			return written, total, err
+		}
+		return written, total, &interruptedError{err}
 	}
 
-	// Log actual download time for GET requests
-	if requestType == "GET" && res.StatusCode/100 == 2 && res.ContentLength > 0 {
+	if contentLength >= 0 && written != contentLength {
+		log.Printf("incomplete response Body from Store API: received %d of %d bytes", written, contentLength)
+		return written, total, &interruptedError{fmt.Errorf("incomplete response Body from Store API: received %d of %d bytes", written, contentLength)}
+	}
+
+	// Log actual download time
+	if contentLength > 0 {
 		elapsed := time.Since(startTime)
 		log.Printf("Download completed in %.2fs", elapsed.Seconds())
 	}
 
-	if res.StatusCode/100 != 2 {
-		var errParse SDError
-		parseError := json.Unmarshal(body, &errParse)
-		if parseError != nil {
-			log.Printf("unparsable error response from Store API: %v", parseError)
-			return nil, fmt.Errorf("unparsable error response from Store API: %v", parseError)
+	return written, total, nil
+}
+
+// fetch streams the content at url to w, resuming an interrupted download with a Range request
+// up to the configured number of retries. When w is a regular file, the content is written from its current offset.
+// When h is not nil, the content is also written to h. It returns the number of bytes written
+func (s *sdStore) fetch(url string, w io.Writer, h hash.Hash) (int64, error) {
+	var base, offset int64
+	file, isFile := w.(*os.File)
+	if isFile {
+		// a pipe or a terminal, such as os.Stdout, cannot be truncated to restart the download
+		if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+			isFile = false
+		}
+	}
+	if isFile {
+		var err error
+		if base, err = file.Seek(0, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+
+	dst := w
+	if h != nil {
+		dst = io.MultiWriter(w, h)
+	}
+
+	reset := func() error {
+		switch {
+		case isFile:
+			if err := file.Truncate(base); err != nil {
+				return err
+			}
+			if _, err := file.Seek(base, io.SeekStart); err != nil {
+				return err
+			}
+		case w != ioutil.Discard:
+			return fmt.Errorf("unable to restart the download of %s", url)
 		}
+		if h != nil {
+			h.Reset()
+		}
+		offset = 0
+		return nil
+	}
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		written, total, err := s.download(url, dst, offset, reset)
+		offset += written
+		if err == nil && total >= 0 && offset != total {
+			err = fmt.Errorf("downloaded %d bytes, expected %d", offset, total)
+		}
+		if err == nil {
+			if !isSidecar(url) {
+				recordTransfer(0, offset)
+			}
+			return offset, nil
+		}
+
+		var interrupted *interruptedError
+		if !errors.As(err, &interrupted) || attempt >= s.client.RetryMax || s.retryPolicy.expired(start) {
+			return offset, err
+		}
+
+		wait := s.client.Backoff(s.client.RetryWaitMin, s.client.RetryWaitMax, attempt, nil)
+		log.Printf("Download of %s interrupted after %s: retrying in %s (%d left)", url, formatBytes(offset), wait, s.client.RetryMax-attempt)
+		time.Sleep(wait)
+	}
+}
+
+// downloadContent streams the content at url to w. When the file was uploaded in parts,
+// the parts listed in its manifest are downloaded one after the other
+func (s *sdStore) downloadContent(url string, w io.Writer) (int64, error) {
+	written, err := s.fetch(url, w, nil)
+	if !errors.Is(err, ErrNotFound) {
+		return written, err
+	}
+
+	manifest, manifestErr := s.getPartsManifest(url)
+	if errors.Is(manifestErr, ErrNotFound) {
+		return 0, err
+	}
+	if manifestErr != nil {
+		return 0, manifestErr
+	}
+
+	return s.fetchParts(url, manifest, w)
+}
+
+// downloadToFile streams the content at url to a temporary file next to filePath,
+// and moves it to filePath once the download is complete
+func (s *sdStore) downloadToFile(url string, filePath string) error {
+	dir, file := filepath.Split(filePath)
+	tmpPath := filepath.Join(dir, fmt.Sprintf(".%s.download-%d", file, time.Now().UnixNano()))
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	_, err = s.downloadContent(url, tmpFile)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	// ensure file is flushed
+	err = tmpFile.Sync()
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filePath)
+}
+
+// request sends a request and reads the whole response body; it should only be used for small bodies.
+// The request is sent again when reading the body fails, up to the configured number of retries
+func (s *sdStore) request(url string, requestType string) ([]byte, error) {
+	defer s.client.HTTPClient.CloseIdleConnections()
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		res, err := s.do(url, requestType, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err == nil {
+			return body, nil
+		}
+
+		log.Printf("reading response Body from Store API: %v", err)
+		if attempt >= s.client.RetryMax || s.retryPolicy.expired(start) {
+			return nil, fmt.Errorf("reading response Body from Store API: %v", err)
+		}
+
+		wait := s.client.Backoff(s.client.RetryWaitMin, s.client.RetryWaitMax, attempt, nil)
+		log.Printf("Reading response of %s(%s) interrupted: retrying in %s (%d left)", requestType, url, wait, s.client.RetryMax-attempt)
+		time.Sleep(wait)
+	}
+}
 
+// do sends a request with the given headers to the Store API. A response with a non 2xx status code
+// is returned as an error; otherwise the caller should close response.Body
+func (s *sdStore) do(url string, requestType string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(requestType, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Generating request to Screwdriver: %v", err)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Authorization", tokenHeader(s.token))
+	req = req.WithContext(withRetryStart(req.Context()))
+
+	res, err := s.client.StandardClient().Do(req)
+	if err != nil {
+		if res != nil {
+			res.Body.Close()
+		}
+		log.Printf("WARNING: received error from %s(%s): %v ", requestType, url, err)
+		return nil, fmt.Errorf("WARNING: received error from %s(%s): %v ", requestType, url, err)
+	}
+
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		return nil, responseError(res, url)
+	}
+
+	return res, nil
+}
+
+// responseError reads the error response returned by the Store API
+func responseError(res *http.Response, url string) error {
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Printf("reading response Body from Store API: %v", err)
+		return fmt.Errorf("reading response Body from Store API: %v", err)
+	}
+
+	var errParse SDError
+	parseError := json.Unmarshal(body, &errParse)
+	if res.Request != nil && res.Request.Method == "HEAD" {
+		// responses to HEAD requests have no body
+		parseError = nil
+	}
+	if res.StatusCode == http.StatusNotFound {
 		log.Printf("WARNING: received response %d from %s ", res.StatusCode, url)
-		return nil, fmt.Errorf("WARNING: received response %d from %s ", res.StatusCode, url)
+		return fmt.Errorf("WARNING: received response %d from %s: %w", res.StatusCode, url, ErrNotFound)
+	}
+	if parseError != nil {
+		// with the s3 strategy, the errors are returned by S3
+		var errS3 s3Error
+		if xml.Unmarshal(body, &errS3) == nil && errS3.Code != "" {
+			log.Printf("WARNING: received response %d from %s: %s: %s", res.StatusCode, url, errS3.Code, errS3.Message)
+			return fmt.Errorf("WARNING: received response %d from %s: %s: %s", res.StatusCode, url, errS3.Code, errS3.Message)
+		}
+		if len(body) > 512 {
+			body = body[:512]
+		}
+		log.Printf("unparsable error response %d from %s: %v, body: %q", res.StatusCode, url, parseError, body)
+		return fmt.Errorf("unparsable error response %d from %s: %v, body: %q", res.StatusCode, url, parseError, body)
 	}
 
-	return body, nil
+	log.Printf("WARNING: received response %d from %s ", res.StatusCode, url)
+	return fmt.Errorf("WARNING: received response %d from %s ", res.StatusCode, url)
+}
+
+// upload writes a file at filePath to a url, in parts when the file is larger than the chunk threshold
+func (s *sdStore) upload(u *url.URL, bodyType string, filePath string, useExpectHeader bool) error {
+	fi, err := os.Stat(filePath)
+	if err == nil && s.chunkThreshold > 0 && fi.Size() > s.chunkThreshold {
+		return s.putParts(u, bodyType, filePath, fi.Size(), useExpectHeader)
+	}
+
+	if err = s.putFile(u, bodyType, filePath, useExpectHeader); err != nil {
+		return err
+	}
+	// the parts of a larger file uploaded before at the same url are left otherwise. They can only be there
+	// when uploads in parts are enabled, and Remove removes them anyway
+	if s.chunkThreshold > 0 {
+		if err := s.removeParts(u, nil); err != nil {
+			log.Printf("Unable to remove the parts of %s, continuing: %v", u.String(), err)
+		}
+	}
+
+	return nil
 }
 
 // putFile writes a file at filePath to a url with a PUT request. It streams the data from disk to save memory
 func (s *sdStore) putFile(url *url.URL, bodyType string, filePath string, useExpectHeader bool) error {
-	requestType := "PUT"
-	req, err := retryablehttp.NewRequest(requestType, url.String(), func() (io.Reader, error) {
+	var fileSize int64 = -1
+	if fi, err := os.Stat(filePath); err == nil {
+		fileSize = fi.Size()
+		// Display file size and estimated upload time
+		log.Printf("Uploading: %s", formatBytes(fileSize))
+	}
+
+	startTime := time.Now()
+	err := s.putBody(url, bodyType, fileSize, func() (io.Reader, error) {
 		return os.Open(filePath)
-	})
+	}, useExpectHeader)
+	if err != nil {
+		return err
+	}
+
+	// Log actual upload time
+	if fileSize > 0 {
+		elapsed := time.Since(startTime)
+		log.Printf("Upload completed in %.2fs", elapsed.Seconds())
+	}
+
+	return nil
+}
+
+// putBody writes the body returned by getBody to a url with a PUT request.
+// getBody is called again for every retry; size is the length of the body or -1 if unknown
+func (s *sdStore) putBody(url *url.URL, bodyType string, size int64, getBody func() (io.Reader, error), useExpectHeader bool) error {
+	requestType := "PUT"
+	req, err := retryablehttp.NewRequest(requestType, url.String(), getBody)
 	if err != nil {
 		log.Printf("WARNING: received error generating new request for %s(%s): %v ", requestType, url.String(), err)
 		return fmt.Errorf("WARNING: received error generating new request for %s(%s): %v ", requestType, url.String(), err)
@@ -413,20 +1037,16 @@ func (s *sdStore) putFile(url *url.URL, bodyType string, filePath string, useExp
 
 	req.Header.Set("Authorization", tokenHeader(s.token))
 	req.Header.Set("Content-Type", bodyType)
+	req = req.WithContext(withRetryStart(req.Context()))
 
 	if useExpectHeader {
 		req.Header.Set("Expect", "100-continue")
 	}
 
-	var fileSize int64
-	if fi, err := os.Stat(filePath); err == nil {
-		req.ContentLength = fi.Size()
-		fileSize = fi.Size()
-		// Display file size and estimated upload time
-		log.Printf("Uploading: %s", formatBytes(fileSize))
+	if size >= 0 {
+		req.ContentLength = size
 	}
 
-	startTime := time.Now()
 	res, err := s.client.Do(req)
 	if res != nil {
 		defer res.Body.Close()
@@ -437,28 +1057,17 @@ func (s *sdStore) putFile(url *url.URL, bodyType string, filePath string, useExp
 		return fmt.Errorf("WARNING: received error from %s(%s): %v ", requestType, url.String(), err)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	if res.StatusCode/100 != 2 {
+		return responseError(res, url.String())
+	}
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
 	if err != nil {
 		log.Printf("reading response Body from Store API: %v", err)
 		return fmt.Errorf("reading response Body from Store API: %v", err)
 	}
-
-	if res.StatusCode/100 != 2 {
-		var errParse SDError
-		parseError := json.Unmarshal(body, &errParse)
-		if parseError != nil {
-			log.Printf("unparsable error response from Store API: %v", parseError)
-			return fmt.Errorf("unparsable error response from Store API: %v", parseError)
-		}
-
-		log.Printf("WARNING: received response %d from %s ", res.StatusCode, url.String())
-		return fmt.Errorf("WARNING: received response %d from %s ", res.StatusCode, url.String())
-	}
-
-	// Log actual upload time
-	if fileSize > 0 {
-		elapsed := time.Since(startTime)
-		log.Printf("Upload completed in %.2fs", elapsed.Seconds())
+	if size > 0 && !isSidecar(url.String()) {
+		recordTransfer(size, 0)
 	}
 
 	return nil
@@ -2,6 +2,8 @@ package sdstore
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,11 +12,14 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/screwdriver-cd/store-cli/sdstore/sdstoretest"
 )
 
 func newStore(maxRetries int) *sdStore {
@@ -24,8 +29,8 @@ func newStore(maxRetries int) *sdStore {
 	retryHttpClient.HTTPClient.Timeout = time.Duration(1) * time.Second
 	token := "faketoken"
 	return &sdStore{
-		token,
-		retryHttpClient,
+		token:  token,
+		client: retryHttpClient,
 	}
 }
 
@@ -83,6 +88,13 @@ func makeFakeZipHTTPClient(t *testing.T, code int, body string, v func(r *http.R
 			v(r)
 		}
 
+		// only the legacy zip archive is in the store
+		if strings.Contains(r.URL.Path, ".tar.zst") {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"statusCode":404,"error":"Not Found","message":"Not Found"}`))
+			return
+		}
+
 		w.WriteHeader(code)
 		w.Header().Set("Content-Type", "text/plain")
 		filePath, _ := filepath.Abs("../data/test.zip")
@@ -115,14 +127,6 @@ func testFile2() *os.File {
 	return f
 }
 
-func testZipFile() *os.File {
-	f, err := os.Open("../data/emitterdata.zip")
-	if err != nil {
-		panic(err)
-	}
-	return f
-}
-
 func checkModTime(t *testing.T, path string, expectedTime string) {
 	const format = "2006-01-02 15:04:05 -0700"
 	expected, err := time.Parse(format, expectedTime)
@@ -188,9 +192,9 @@ func TestUpload(t *testing.T) {
 	}
 }
 
-func TestUploadZipWithChange(t *testing.T) {
+func TestUploadArchiveWithChange(t *testing.T) {
 	file := "../data/emitterdata"
-	zipfile := file + ".zip"
+	archivefile := file + ".tar.zst"
 	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/123/" + file)
 	uploader := newStore(2)
 	called := 0
@@ -217,37 +221,35 @@ func TestUploadZipWithChange(t *testing.T) {
 			getMd5 = true
 		} else if r.Method == "PUT" && contentType == "text/plain" {
 			putZip = true
-			err := ioutil.WriteFile(zipfile, content, 0644)
+			if !strings.HasSuffix(r.URL.Path, ".tar.zst") {
+				t.Errorf("Wrong URL path, needs to be a tar.zst file: %s", r.URL.Path)
+			}
+
+			err := ioutil.WriteFile(archivefile, content, 0644)
 			if err != nil {
 				panic(err)
 			}
 
-			files, err := Unzip(zipfile, "../data/test")
-
-			var filecontent []byte
-			if len(files) == 1 {
-				filecontent, err = ioutil.ReadFile(files[0])
+			_ = os.MkdirAll("../data/test", 0777)
+			err = Decompress(archivefile, "../data/test")
+			if err != nil {
+				panic(err)
 			}
 
+			filecontent, _ := ioutil.ReadFile("../data/test/emitterdata")
 			if string(filecontent[:]) != string(wantcontent[:]) {
 				t.Errorf("Received payload %s, want %s", filecontent, wantcontent)
 			}
 
-			stat, err := testZipFile().Stat()
-			if err != nil {
-				t.Fatalf("Couldn't stat test file: %v", err)
-			}
-
-			fsize := stat.Size()
-			if r.ContentLength != fsize {
-				t.Errorf("Wrong Content-Length sent to uploader. Got %d, want %d", r.ContentLength, fsize)
+			if r.ContentLength != int64(len(content)) {
+				t.Errorf("Wrong Content-Length sent to uploader. Got %d, want %d", r.ContentLength, len(content))
 			}
 
 			if r.Header.Get("Expect") != "100-continue" {
 				t.Errorf("Expected 'Expect: 100-continue' header, but got %v", r.Header.Get("Expect"))
 			}
 
-			err = os.Remove(zipfile)
+			err = os.Remove(archivefile)
 			if err != nil {
 				panic(err)
 			}
@@ -277,7 +279,7 @@ func TestUploadZipWithChange(t *testing.T) {
 	}
 
 	if !putZip {
-		t.Errorf("Did not upload zip file")
+		t.Errorf("Did not upload archive file")
 	}
 
 	if !putMd5 {
@@ -394,8 +396,8 @@ func TestDownloadZip(t *testing.T) {
 	called := false
 
 	http := makeFakeZipHTTPClient(t, 200, "OK", func(r *http.Request) {
-		if r.URL.Path != fmt.Sprintf("%s%s", u.Path, ".zip") {
-			t.Errorf("Wrong URL path, needs to be a zip file: %s", r.URL.Path)
+		if r.URL.Path != fmt.Sprintf("%s%s", u.Path, ".zip") && !strings.HasPrefix(r.URL.Path, fmt.Sprintf("%s%s", u.Path, ".tar.zst")) {
+			t.Errorf("Wrong URL path, needs to be a tar.zst or zip file: %s", r.URL.Path)
 		}
 
 		called = true
@@ -429,6 +431,40 @@ func TestDownloadZip(t *testing.T) {
 	}
 }
 
+func TestDownloadTarZst(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tarzst")
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src", "cached")
+	_ = os.MkdirAll(filepath.Join(src, "lib"), 0777)
+	_ = ioutil.WriteFile(filepath.Join(src, "lib", "file"), []byte("test-content"), 0644)
+	archive := filepath.Join(dir, "cached.tar.zst")
+	fInfos, _, _ := getMetadataInfo(src, nil)
+	if err := Compress(filepath.Dir(src), archive, fInfos); err != nil {
+		t.Fatalf("Unable to compress: %v", err)
+	}
+	_ = os.RemoveAll(src)
+
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/" + url.PathEscape(src))
+	downloader := newStore(2)
+	client, objects := makeFakeObjectStoreHTTPClient(t)
+	objects[u.EscapedPath()+".tar.zst"], _ = ioutil.ReadFile(archive)
+	downloader.client.HTTPClient = client
+
+	err := downloader.Download(u, true)
+	if err != nil {
+		t.Fatalf("Expected nil from downloader.Download(), got %v", err)
+	}
+
+	got, _ := ioutil.ReadFile(filepath.Join(src, "lib", "file"))
+	if string(got) != "test-content" {
+		t.Errorf("Response is %s, want test-content", got)
+	}
+	if _, err := os.Stat(src + ".tar.zst"); err == nil {
+		t.Errorf("Expected the archive to be removed after extraction")
+	}
+}
+
 func TestDownloadRetry(t *testing.T) {
 	u, _ := url.Parse("http://fakestore.example.com/builds/1234-test")
 	downloader := newStore(2)
@@ -515,28 +551,171 @@ func TestDownloadWriteBackSpecialFile(t *testing.T) {
 	}
 }
 
+func TestDownloadTruncated(t *testing.T) {
+	testfilepath := "/tmp/test-data/truncated/file"
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/" + testfilepath)
+	downloader := newStore(0)
+	os.RemoveAll(filepath.Dir(testfilepath))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(200)
+		w.Write([]byte("test-content"))
+	}))
+	defer server.Close()
+
+	downloader.client.HTTPClient = &http.Client{Transport: &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}}
+
+	err := downloader.Download(u, false)
+	if err == nil {
+		t.Fatalf("Expected error from downloader.Download(), got nil")
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Dir(testfilepath))
+	if len(files) != 0 {
+		t.Errorf("Expected no file to be written, got %d", len(files))
+	}
+}
+
+func makeFakeResumeHTTPClient(t *testing.T, content string, honorRange bool) (*http.Client, *[]string) {
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// break the connection half way through the first response
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+			w.WriteHeader(200)
+			w.Write([]byte(content[:len(content)/2]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		if !honorRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}}
+
+	return client, &ranges
+}
+
+func TestDownloadResume(t *testing.T) {
+	testfilepath := "/tmp/test-data/resume/file"
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/" + testfilepath)
+	downloader := newStore(2)
+	downloader.client.RetryWaitMin = 10 * time.Millisecond
+	downloader.client.RetryWaitMax = 10 * time.Millisecond
+
+	want := "0123456789abcdefghijklmnopqrstuvwxyz"
+	http, ranges := makeFakeResumeHTTPClient(t, want, true)
+	downloader.client.HTTPClient = http
+
+	err := downloader.Download(u, false)
+	if err != nil {
+		t.Fatalf("Expected nil from downloader.Download(), got %v", err)
+	}
+
+	if len(*ranges) != 2 || (*ranges)[1] != fmt.Sprintf("bytes=%d-", len(want)/2) {
+		t.Errorf("Expected a second request resuming at byte %d, got ranges %q", len(want)/2, *ranges)
+	}
+
+	filecontent, _ := ioutil.ReadFile(testfilepath)
+	if string(filecontent) != want {
+		t.Errorf("File content is %s, want %s", string(filecontent), want)
+	}
+}
+
+func TestDownloadResumeRangeIgnored(t *testing.T) {
+	testfilepath := "/tmp/test-data/resume/ignored"
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/" + testfilepath)
+	downloader := newStore(2)
+	downloader.client.RetryWaitMin = 10 * time.Millisecond
+	downloader.client.RetryWaitMax = 10 * time.Millisecond
+
+	want := "0123456789abcdefghijklmnopqrstuvwxyz"
+	http, ranges := makeFakeResumeHTTPClient(t, want, false)
+	downloader.client.HTTPClient = http
+
+	err := downloader.Download(u, false)
+	if err != nil {
+		t.Fatalf("Expected nil from downloader.Download(), got %v", err)
+	}
+
+	if len(*ranges) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(*ranges))
+	}
+
+	filecontent, _ := ioutil.ReadFile(testfilepath)
+	if string(filecontent) != want {
+		t.Errorf("File content is %s, want %s", string(filecontent), want)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		contentRange string
+		start        int64
+		total        int64
+		shouldError  bool
+	}{
+		{"bytes 100-199/200", 100, 200, false},
+		{"bytes 0-0/*", 0, -1, false},
+		{"bytes 100-199/150", 0, 0, true},
+		{"invalid", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentRange, func(t *testing.T) {
+			start, total, err := parseContentRange(tt.contentRange)
+			if (err != nil) != tt.shouldError {
+				t.Fatalf("expected error %t, got %v", tt.shouldError, err)
+			}
+			if start != tt.start || total != tt.total {
+				t.Errorf("expected %d/%d, got %d/%d", tt.start, tt.total, start, total)
+			}
+		})
+	}
+}
+
+func TestDownloadErrorResponse(t *testing.T) {
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234//tmp/test-data/missing")
+	downloader := newStore(0)
+
+	http := makeFakeHTTPClient(t, 404, `{"statusCode":404,"error":"Not Found","message":"not found"}`, nil)
+	downloader.client.HTTPClient = http
+	err := downloader.Download(u, false)
+	if err == nil || !strings.Contains(err.Error(), "received response 404") {
+		t.Errorf("Expected 404 error from downloader.Download(), got %v", err)
+	}
+}
+
 func TestRemove(t *testing.T) {
 	u, _ := url.Parse("http://fakestore.example.com/builds/1234-test")
 	removeRes := newStore(2)
-	called := false
-
-	http := makeFakeHTTPClient(t, 202, "OK", func(r *http.Request) {
-		called = true
 
-		if r.Method != "DELETE" {
-			t.Errorf("Called with method %s, want DELETE", r.Method)
-		}
-	})
+	// the parts manifest is looked up too, in case the file was uploaded in parts before
+	http, objects := makeFakeObjectStoreHTTPClient(t)
+	objects[u.EscapedPath()] = []byte("log")
 
 	removeRes.client.HTTPClient = http
 	err := removeRes.Remove(u)
 
 	if err != nil {
-		t.Error("Expected nil from removeRes.Remove(), got error")
+		t.Errorf("Expected nil from removeRes.Remove(), got %v", err)
 	}
 
-	if !called {
-		t.Fatalf("The HTTP client was never used.")
+	if len(objects) != 0 {
+		t.Fatalf("Expected the file to be removed, got %d objects left", len(objects))
 	}
 }
 
@@ -558,6 +737,97 @@ func TestRemoveRetry(t *testing.T) {
 	}
 }
 
+func TestList(t *testing.T) {
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1234/")
+	lister := newStore(2)
+
+	body := `[{"key":"/tmp/a.zip","size":10,"lastModified":"2024-01-02T03:04:05Z"},` +
+		`{"key":"/tmp/a_md5.json","size":2,"lastModified":"2024-01-02T03:04:05Z"},` +
+		`{"key":"/tmp/b.zip.part-00001","size":100,"lastModified":"2024-01-02T03:04:05Z"},` +
+		`{"key":"/tmp/b.zip.part-00002","size":50,"lastModified":"2024-01-02T03:04:06Z"},` +
+		`{"key":"/tmp/b.zip.parts.json","size":5,"lastModified":"2024-01-02T03:04:07Z"}]`
+	http := makeFakeHTTPClient(t, 200, body, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Called with method %s, want GET", r.Method)
+		}
+	})
+	lister.client.HTTPClient = http
+
+	items, err := lister.List(u)
+	if err != nil {
+		t.Fatalf("Expected nil from lister.List(), got %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 items, got %v", items)
+	}
+	if items[2].Key != "/tmp/b.zip" || items[2].Size != 150 || items[2].LastModified.Second() != 7 {
+		t.Errorf("Expected the parts of /tmp/b.zip to be merged, got %v", items[2])
+	}
+
+	caches := CacheItems(items)
+	if len(caches) != 2 || caches[0].Key != "/tmp/a" || caches[1].Key != "/tmp/b" {
+		t.Errorf("Unexpected cache items %v", caches)
+	}
+}
+
+func TestStat(t *testing.T) {
+	u, _ := url.Parse("http://fakestore.example.com/v1/builds/1234/ARTIFACTS/report.json")
+	store := newStore(2)
+
+	client, objects := makeFakeObjectStoreHTTPClient(t)
+	store.client.HTTPClient = client
+
+	_, err := store.Stat(u)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from store.Stat(), got %v", err)
+	}
+
+	objects[u.EscapedPath()] = []byte("{}")
+	info, err := store.Stat(u)
+	if err != nil {
+		t.Fatalf("Expected nil from store.Stat(), got %v", err)
+	}
+	if info.Size != 2 || !info.LastModified.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected stat result %v", info)
+	}
+}
+
+func TestStatError(t *testing.T) {
+	u, _ := url.Parse("http://fakestore.example.com/v1/builds/1234/ARTIFACTS/report.json")
+	store := newStore(0)
+
+	http := makeFakeHTTPClient(t, 403, "", func(r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Errorf("Called with method %s, want HEAD", r.Method)
+		}
+	})
+	store.client.HTTPClient = http
+
+	_, err := store.Stat(u)
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "received response 403") {
+		t.Errorf("Expected 403 error from store.Stat(), got %v", err)
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	testCases := []struct {
+		statusCode int
+		body       string
+		expected   string
+	}{
+		{403, `{"statusCode":403,"error":"Forbidden","message":"Insufficient scope"}`, "received response 403 from http://store/item"},
+		{403, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, "received response 403 from http://store/item: AccessDenied: Access Denied"},
+		{502, `<html>Bad Gateway</html>`, `unparsable error response 502 from http://store/item: invalid character '<' looking for beginning of value, body: "<html>Bad Gateway</html>"`},
+	}
+
+	for _, tc := range testCases {
+		res := &http.Response{StatusCode: tc.statusCode, Body: ioutil.NopCloser(strings.NewReader(tc.body))}
+		if err := responseError(res, "http://store/item"); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Expected an error with %q for %s, got %v", tc.expected, tc.body, err)
+		}
+	}
+}
+
 func TestZipAndUnzipWithSymlink(t *testing.T) {
 	err := Zip("../data/testsymlink", "../data/testsymlink.zip")
 
@@ -610,3 +880,117 @@ func TestGetExpectContinueTimeout(t *testing.T) {
 
 	os.Unsetenv("SD_EXPECT_CONTINUE_TIMEOUT")
 }
+
+func TestCompareMD5(t *testing.T) {
+	expected := map[string]string{"a": "1", "dir/b": "2", "dir/c": "3"}
+	actual := map[string]string{"./a": "1", "dir/c": "4", "dir/d": "5"}
+
+	missing, extra, corrupted := CompareMD5(expected, actual)
+	if !reflect.DeepEqual(missing, []string{"dir/b"}) {
+		t.Errorf("unexpected missing files %v", missing)
+	}
+	if !reflect.DeepEqual(extra, []string{"dir/d"}) {
+		t.Errorf("unexpected extra files %v", extra)
+	}
+	if !reflect.DeepEqual(corrupted, []string{"dir/c"}) {
+		t.Errorf("unexpected corrupted files %v", corrupted)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	store := newStore(0)
+	client, objects := makeFakeObjectStoreHTTPClient(t)
+	store.client.HTTPClient = client
+
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b"), []byte("b"), 0644)
+
+	sums, err := MD5All(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error computing md5: %v", err)
+	}
+	md5JSON, _ := json.Marshal(sums)
+
+	u, _ := url.Parse("http://fakestore.example.com/v1/caches/events/1/cache")
+	objects[u.EscapedPath()+"_md5.json"] = md5JSON
+
+	if err := store.Verify(u, dir); err != nil {
+		t.Errorf("Unexpected error verifying unchanged files: %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "b"), []byte("changed"), 0644)
+	err = store.Verify(u, dir)
+	if err == nil || !strings.Contains(err.Error(), "0 missing, 0 extra, 1 corrupted") {
+		t.Errorf("Expected a verification error, got %v", err)
+	}
+
+	delete(objects, u.EscapedPath()+"_md5.json")
+	if err := store.Verify(u, dir); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a not found error without md5 json, got %v", err)
+	}
+}
+
+func TestStoreWithInjectedFaults(t *testing.T) {
+	server := sdstoretest.NewServer("faketoken")
+	defer server.Close()
+	store := newStore(3)
+	store.client.RetryWaitMin = time.Millisecond
+	store.client.RetryWaitMax = time.Millisecond
+
+	dir := t.TempDir()
+	want := bytes.Repeat([]byte("0123456789"), 100)
+	filePath := filepath.Join(dir, "report.json")
+	_ = ioutil.WriteFile(filePath, want, 0644)
+	u, _ := url.Parse(server.StoreURL() + "builds/1234/ARTIFACTS/report.json")
+
+	// a burst of 5xx responses is retried
+	server.FailNext(2, http.StatusServiceUnavailable)
+	if err := store.Upload(u, filePath, false, false); err != nil {
+		t.Fatalf("Expected the upload to be retried, got %v", err)
+	}
+
+	// a truncated body is resumed
+	server.TruncateNext(1, 300)
+	downloadPath := filepath.Join(dir, "downloaded.json")
+	if err := store.downloadToFile(u.String(), downloadPath); err != nil {
+		t.Fatalf("Expected the download to be resumed, got %v", err)
+	}
+	if got, _ := ioutil.ReadFile(downloadPath); !bytes.Equal(got, want) {
+		t.Errorf("Downloaded %d bytes, want %d", len(got), len(want))
+	}
+
+	// a 413 response is not retried
+	server.SetMaxBodySize(100)
+	requests := len(server.Requests())
+	err := store.Upload(u, filePath, false, false)
+	if err == nil || !strings.Contains(err.Error(), "413") {
+		t.Errorf("Expected a 413 error, got %v", err)
+	}
+	if sent := len(server.Requests()) - requests; sent != 1 {
+		t.Errorf("Expected 1 request, got %d", sent)
+	}
+}
+
+func TestUploadFromReader(t *testing.T) {
+	server := sdstoretest.NewServer("faketoken")
+	defer server.Close()
+	store := newStore(3)
+	store.client.RetryWaitMin = time.Millisecond
+	store.client.RetryWaitMax = time.Millisecond
+	u, _ := url.Parse(server.StoreURL() + "builds/1234/ARTIFACTS/report.json")
+
+	// the spooled content is sent again when the upload is retried
+	server.FailNext(2, http.StatusServiceUnavailable)
+	if err := store.UploadFromReader(u, strings.NewReader(`{"passed":true}`), UploadOptions{}); err != nil {
+		t.Fatalf("Expected the upload to be retried, got %v", err)
+	}
+	item, ok := server.Get("builds/1234/ARTIFACTS/report.json")
+	if !ok || string(item.Body) != `{"passed":true}` || item.ContentType != "application/json" {
+		t.Errorf("Unexpected uploaded item %v", item)
+	}
+
+	if err := store.UploadFromReader(u, strings.NewReader("archive"), UploadOptions{Compress: true}); err == nil {
+		t.Errorf("Expected an error when compressing a stream")
+	}
+}
@@ -0,0 +1,303 @@
+// Package sdstoretest provides an in-memory fake of the Screwdriver Store API for tests.
+//
+// It does not depend on the sdstore package, so it can be used by the tests of sdstore itself.
+package sdstoretest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIPrefix is the path of the Store API, StoreURL returns the url of the server followed by it
+const APIPrefix = "/v1/"
+
+// paths served by the fake Store API, relative to APIPrefix
+var (
+	itemPath = regexp.MustCompile(`^(caches/(?:events|jobs|pipelines)/[0-9]+/|builds/[0-9]+/ARTIFACTS/)(.+)$`)
+	listPath = regexp.MustCompile(`^(caches/(?:events|jobs|pipelines)/[0-9]+/|builds/[0-9]+/ARTIFACTS/)$`)
+	logPath  = regexp.MustCompile(`^builds/[0-9]+-.+$`)
+)
+
+// Item is an item kept by the fake Store API
+type Item struct {
+	Body         []byte
+	ContentType  string
+	LastModified time.Time
+}
+
+// listedItem is an item listed by the fake Store API
+type listedItem struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// sdError is the body of an error response of the Store API
+type sdError struct {
+	StatusCode int    `json:"statusCode"`
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+}
+
+// Server is an in-memory fake of the Store API. Items are keyed by their escaped path relative to APIPrefix,
+// e.g. caches/events/1234/%2Ftmp%2Fcache.tar.zst or builds/5678/ARTIFACTS/report.json
+type Server struct {
+	*httptest.Server
+
+	// Token is the token requests must send as "Authorization: Bearer <token>"
+	Token string
+
+	mu          sync.Mutex
+	items       map[string]*Item
+	requests    []string
+	latency     time.Duration
+	failures    int
+	failureCode int
+	truncations int
+	truncateAt  int
+	maxBodySize int64
+}
+
+// NewServer starts a fake Store API accepting token. The server should be closed when the test is done
+func NewServer(token string) *Server {
+	s := &Server{Token: token, items: map[string]*Item{}, maxBodySize: -1}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// StoreURL returns the url to set SD_STORE_URL to
+func (s *Server) StoreURL() string {
+	return s.URL + APIPrefix
+}
+
+// SetLatency delays every response by latency
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// FailNext answers the next count requests with statusCode, e.g. a burst of 503 responses
+func (s *Server) FailNext(count int, statusCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = count
+	s.failureCode = statusCode
+}
+
+// TruncateNext cuts the body of the next count GET responses after size bytes, while announcing the full length
+func (s *Server) TruncateNext(count int, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncations = count
+	s.truncateAt = size
+}
+
+// SetMaxBodySize answers uploads larger than size bytes with 413 Payload Too Large. A negative size removes the limit
+func (s *Server) SetMaxBodySize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxBodySize = size
+}
+
+// Put stores an item at path
+func (s *Server) Put(path string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[path] = &Item{Body: body, LastModified: time.Now().UTC()}
+}
+
+// Get returns the item stored at path
+func (s *Server) Get(path string) (*Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[path]
+
+	return item, ok
+}
+
+// Delete removes the item stored at path
+func (s *Server) Delete(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, path)
+}
+
+// Paths returns the sorted paths of the stored items
+func (s *Server) Paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, 0, len(s.items))
+	for path := range s.items {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Requests returns the requests received so far, as "<method> <escaped path>"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// writeError writes an error response with an SDError body
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	body, _ := json.Marshal(sdError{StatusCode: statusCode, Error: http.StatusText(statusCode), Message: message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), APIPrefix)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.EscapedPath())
+	latency := s.latency
+	failureCode := 0
+	if s.failures > 0 {
+		s.failures--
+		failureCode = s.failureCode
+	}
+	truncateAt := -1
+	if r.Method == "GET" && s.truncations > 0 {
+		s.truncations--
+		truncateAt = s.truncateAt
+	}
+	maxBodySize := s.maxBodySize
+	s.mu.Unlock()
+
+	time.Sleep(latency)
+
+	if r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "Missing authentication")
+		return
+	}
+	if failureCode != 0 {
+		writeError(w, failureCode, "Injected failure")
+		return
+	}
+	if !strings.HasPrefix(r.URL.EscapedPath(), APIPrefix) {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	switch {
+	case listPath.MatchString(path) && (r.Method == "GET" || r.Method == "HEAD"):
+		s.list(w, r, path)
+	case itemPath.MatchString(path) || logPath.MatchString(path):
+		switch r.Method {
+		case "GET", "HEAD":
+			s.get(w, r, path, truncateAt)
+		case "PUT":
+			s.put(w, r, path, maxBodySize)
+		case "DELETE":
+			s.delete(w, path)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed", r.Method))
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, path string, truncateAt int) {
+	item, ok := s.Get(path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if item.ContentType != "" {
+		w.Header().Set("Content-Type", item.ContentType)
+	}
+	if truncateAt >= 0 && truncateAt < len(item.Body) {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(item.Body)))
+		w.Header().Set("Last-Modified", item.LastModified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		w.Write(item.Body[:truncateAt])
+		// the connection is closed as less than Content-Length bytes were written
+		return
+	}
+
+	http.ServeContent(w, r, "", item.LastModified, bytes.NewReader(item.Body))
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, path string, maxBodySize int64) {
+	if maxBodySize >= 0 && r.ContentLength > maxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, "Payload content length greater than maximum allowed")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if maxBodySize >= 0 && int64(len(body)) > maxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, "Payload content length greater than maximum allowed")
+		return
+	}
+
+	s.mu.Lock()
+	s.items[path] = &Item{Body: body, ContentType: r.Header.Get("Content-Type"), LastModified: time.Now().UTC()}
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) delete(w http.ResponseWriter, path string) {
+	s.mu.Lock()
+	_, ok := s.items[path]
+	delete(s.items, path)
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list writes the items stored under path as a JSON array, keyed by their unescaped path relative to path
+func (s *Server) list(w http.ResponseWriter, r *http.Request, path string) {
+	items := []listedItem{}
+	for _, itemPath := range s.Paths() {
+		if !strings.HasPrefix(itemPath, path) {
+			continue
+		}
+		item, ok := s.Get(itemPath)
+		if !ok {
+			continue
+		}
+
+		key, err := url.PathUnescape(strings.TrimPrefix(itemPath, path))
+		if err != nil {
+			key = strings.TrimPrefix(itemPath, path)
+		}
+		items = append(items, listedItem{Key: key, Size: int64(len(item.Body)), LastModified: item.LastModified})
+	}
+
+	body, _ := json.Marshal(items)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "HEAD" {
+		return
+	}
+	w.Write(body)
+}
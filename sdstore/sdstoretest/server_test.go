@@ -0,0 +1,127 @@
+package sdstoretest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func do(t *testing.T, s *Server, method, path, token, body string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, s.StoreURL()+path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	resBody, _ := ioutil.ReadAll(res.Body)
+
+	return res, string(resBody)
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer("faketoken")
+	defer s.Close()
+
+	res, _ := do(t, s, "PUT", "caches/events/1234/%2Ftmp%2Fcache.tar.zst", "faketoken", "archive")
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("PUT returned %d, want %d", res.StatusCode, http.StatusAccepted)
+	}
+	do(t, s, "PUT", "builds/5678/ARTIFACTS/report.json", "faketoken", "{}")
+	do(t, s, "PUT", "builds/5678-step-test", "faketoken", "log")
+
+	res, body := do(t, s, "GET", "caches/events/1234/%2Ftmp%2Fcache.tar.zst", "faketoken", "")
+	if res.StatusCode != http.StatusOK || body != "archive" {
+		t.Errorf("GET returned %d %q", res.StatusCode, body)
+	}
+
+	res, body = do(t, s, "GET", "caches/events/1234/", "faketoken", "")
+	var items []listedItem
+	if err := json.Unmarshal([]byte(body), &items); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected list response %d %q", res.StatusCode, body)
+	}
+	if len(items) != 1 || items[0].Key != "/tmp/cache.tar.zst" || items[0].Size != 7 {
+		t.Errorf("Unexpected items %v", items)
+	}
+
+	res, _ = do(t, s, "DELETE", "builds/5678-step-test", "faketoken", "")
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE returned %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+	if paths := s.Paths(); len(paths) != 2 {
+		t.Errorf("Unexpected paths %v", paths)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	s := NewServer("faketoken")
+	defer s.Close()
+
+	tests := []struct {
+		method, path, token string
+		statusCode          int
+	}{
+		{"GET", "builds/5678/ARTIFACTS/report.json", "", http.StatusUnauthorized},
+		{"GET", "builds/5678/ARTIFACTS/report.json", "wrongtoken", http.StatusUnauthorized},
+		{"GET", "builds/5678/ARTIFACTS/report.json", "faketoken", http.StatusNotFound},
+		{"DELETE", "builds/5678/ARTIFACTS/report.json", "faketoken", http.StatusNotFound},
+		{"GET", "unknown/path", "faketoken", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		res, body := do(t, s, tt.method, tt.path, tt.token, "")
+		var sdErr sdError
+		if err := json.Unmarshal([]byte(body), &sdErr); err != nil {
+			t.Errorf("%s %s returned unparsable error %q", tt.method, tt.path, body)
+		}
+		if res.StatusCode != tt.statusCode || sdErr.StatusCode != tt.statusCode {
+			t.Errorf("%s %s returned %d %q, want %d", tt.method, tt.path, res.StatusCode, body, tt.statusCode)
+		}
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	s := NewServer("faketoken")
+	defer s.Close()
+	s.Put("builds/5678/ARTIFACTS/report.json", []byte("0123456789"))
+
+	s.FailNext(2, http.StatusServiceUnavailable)
+	for i := 0; i < 2; i++ {
+		if res, _ := do(t, s, "GET", "builds/5678/ARTIFACTS/report.json", "faketoken", ""); res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected injected failure %d, got %d", i, res.StatusCode)
+		}
+	}
+	if res, _ := do(t, s, "GET", "builds/5678/ARTIFACTS/report.json", "faketoken", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected success after the failures, got %d", res.StatusCode)
+	}
+
+	s.TruncateNext(1, 4)
+	req, _ := http.NewRequest("GET", s.StoreURL()+"builds/5678/ARTIFACTS/report.json", nil)
+	req.Header.Set("Authorization", "Bearer faketoken")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil || string(body) != "0123" {
+		t.Errorf("Expected a truncated body, got %q, %v", body, err)
+	}
+
+	s.SetMaxBodySize(5)
+	if res, _ := do(t, s, "PUT", "builds/5678/ARTIFACTS/big.json", "faketoken", "0123456789"); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a large body, got %d", res.StatusCode)
+	}
+
+	s.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	do(t, s, "HEAD", "builds/5678/ARTIFACTS/report.json", "faketoken", "")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected a delayed response, got one after %v", elapsed)
+	}
+}
@@ -0,0 +1,69 @@
+package sdstore
+
+import (
+	"strings"
+	"sync"
+)
+
+// Stats are the bytes the process transferred to and from the store, and the sizes of the archives it compressed
+// or extracted along with the size of their contents
+type Stats struct {
+	Uploaded    int64
+	Downloaded  int64
+	ArchiveSize int64
+	ContentSize int64
+}
+
+var (
+	statsMu sync.Mutex
+	stats   Stats
+)
+
+// GetStats returns the stats of the process so far
+func GetStats() Stats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	return stats
+}
+
+// CompressionRatio returns the size of the contents of the archives over the size of the archives,
+// 0 when no archive was compressed or extracted
+func (s Stats) CompressionRatio() float64 {
+	if s.ArchiveSize <= 0 || s.ContentSize <= 0 {
+		return 0
+	}
+
+	return float64(s.ContentSize) / float64(s.ArchiveSize)
+}
+
+// sidecarSuffixes are the suffixes of the json objects stored next to a cache, which are not counted
+// in the transfers of the cache itself
+var sidecarSuffixes = []string{"_md5.json", PartsManifestSuffix}
+
+// isSidecar returns true when url is the md5 json, metadata or parts manifest of an item
+func isSidecar(url string) bool {
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(url, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func recordTransfer(uploaded, downloaded int64) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	stats.Uploaded += uploaded
+	stats.Downloaded += downloaded
+}
+
+func recordArchive(archiveSize, contentSize int64) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	stats.ArchiveSize += archiveSize
+	stats.ContentSize += contentSize
+}
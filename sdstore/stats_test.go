@@ -0,0 +1,52 @@
+package sdstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressionRatio(t *testing.T) {
+	testCases := []struct {
+		stats    Stats
+		expected float64
+	}{
+		{Stats{}, 0},
+		{Stats{ArchiveSize: 10}, 0},
+		{Stats{ArchiveSize: 10, ContentSize: 40}, 4},
+	}
+
+	for _, tc := range testCases {
+		if ratio := tc.stats.CompressionRatio(); ratio != tc.expected {
+			t.Errorf("Expected a ratio of %v for %+v, got %v", tc.expected, tc.stats, ratio)
+		}
+	}
+}
+
+func TestStatsOfArchives(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	_ = os.MkdirAll(src, 0755)
+	_ = os.WriteFile(filepath.Join(src, "file"), bytes.Repeat([]byte("cached"), 1000), 0644)
+
+	before := GetStats()
+	fInfos, _, _ := getMetadataInfo(src, nil)
+	archive := filepath.Join(dir, "src.tar.zst")
+	if err := Compress(dir, archive, fInfos); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	info, _ := os.Stat(archive)
+
+	compressed := GetStats()
+	if compressed.ArchiveSize-before.ArchiveSize != info.Size() || compressed.ContentSize-before.ContentSize != 6000 {
+		t.Errorf("Expected an archive of %d B with 6000 B of content, got %+v since %+v", info.Size(), compressed, before)
+	}
+
+	if err := Decompress(archive, filepath.Join(dir, "dst")); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if extracted := GetStats(); extracted.ContentSize-compressed.ContentSize != 6000 {
+		t.Errorf("Expected 6000 B of content to be extracted, got %+v since %+v", extracted, compressed)
+	}
+}
@@ -235,17 +235,21 @@ func Unzip(src string, dest string) ([]string, error) {
 	return files, nil
 }
 
-func writeHeader(tw *tar.Writer, fInfo os.FileInfo, path, src string) error {
+// archiveName returns the name in an archive of the file at path, relative to src. src itself is ".", as
+// with tar -c . in src
+func archiveName(path, src string) string {
+	if src != path {
+		return strings.TrimPrefix(path[len(src):], "/")
+	}
+
+	return "."
+}
+
+func writeHeader(tw *tar.Writer, fInfo os.FileInfo, path, fileName string) error {
 	var (
-		link     string
-		fileName string
+		link string
 	)
 	link, _ = os.Readlink(path)
-	if src != path {
-		fileName = path[1+len(src):]
-	} else {
-		fileName = path
-	}
 
 	header, err := tar.FileInfoHeader(fInfo, filepath.ToSlash(link))
 	if err != nil {
@@ -258,6 +262,12 @@ func writeHeader(tw *tar.Writer, fInfo os.FileInfo, path, src string) error {
 }
 
 func Compress(src, dst string, files []*FileInfo) error {
+	return compress(dst, files, func(path string) string { return archiveName(path, src) }, nil)
+}
+
+// compress writes files to the tar.zst archive dst, each under the name returned by name for its path.
+// When prelude is not nil, it is called to write the first entries of the archive
+func compress(dst string, files []*FileInfo, name func(path string) string, prelude func(tw *tar.Writer) error) error {
 	var (
 		err, aggregatedErr error
 		file, dstFile      *os.File
@@ -268,10 +278,17 @@ func Compress(src, dst string, files []*FileInfo) error {
 	if err != nil {
 		return err
 	}
+	// the archive is complete once the writers below are closed
+	var contentSize int64
+	defer func() {
+		if info, err := os.Stat(dst); err == nil {
+			recordArchive(info.Size(), contentSize)
+		}
+	}()
 	defer dstFile.Close()
 
 	zstd.WithAllLitEntropyCompression(false)
-	zw, err = zstd.NewWriter(dstFile, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(CompressionLevel)))
+	zw, err = zstd.NewWriter(dstFile, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(zstdLevel())))
 	if err != nil {
 		return err
 	}
@@ -280,16 +297,22 @@ func Compress(src, dst string, files []*FileInfo) error {
 	tw := tar.NewWriter(zw)
 	defer func() { _ = tw.Close() }()
 
+	if prelude != nil {
+		if err = prelude(tw); err != nil {
+			return err
+		}
+	}
+
 	for _, f := range files {
 		fInfo, _ := os.Lstat(f.Path)
 		if fInfo.Mode().IsDir() {
-			err = writeHeader(tw, fInfo, f.Path, src)
+			err = writeHeader(tw, fInfo, f.Path, name(f.Path))
 			if err != nil {
 				aggregatedErr = multierr.Append(aggregatedErr, err)
 			}
 		} else {
 			if fInfo.Mode()&os.ModeSymlink != 0 {
-				err = writeHeader(tw, fInfo, f.Path, src)
+				err = writeHeader(tw, fInfo, f.Path, name(f.Path))
 				if err != nil {
 					aggregatedErr = multierr.Append(aggregatedErr, err)
 				}
@@ -299,17 +322,19 @@ func Compress(src, dst string, files []*FileInfo) error {
 					aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("ignoring file %q: %v", f, err))
 					continue
 				}
-				err = writeHeader(tw, fInfo, f.Path, src)
+				err = writeHeader(tw, fInfo, f.Path, name(f.Path))
 				if err != nil {
 					file.Close()
 					aggregatedErr = multierr.Append(aggregatedErr, err)
 					continue
 				}
-				if _, err = io.Copy(tw, file); err != nil {
+				written, err := io.Copy(tw, file)
+				if err != nil {
 					file.Close()
 					aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("error copying file %q to tar: %v", f, err))
 					continue
 				}
+				contentSize += written
 				file.Close()
 			}
 		}
@@ -321,13 +346,20 @@ func Compress(src, dst string, files []*FileInfo) error {
 func Decompress(src, dst string) error {
 	var (
 		err, aggregatedErr error
+		restoreErr         error
 		zr                 *zstd.Decoder
 		file, srcFile      *os.File
 		hdr                *tar.Header
 		mtime              [2]unix.Timeval
 		written            int64
+		contentSize        int64
 		fInfos             []*FileInfo
+		fPath              string
 	)
+	// the entries are extracted in dst, unless the archive bundles several paths
+	target := func(name string) (string, error) {
+		return filepath.Join(dst, name), nil
+	}
 
 	srcFile, err = os.Open(src)
 	if err != nil {
@@ -350,25 +382,35 @@ func Decompress(src, dst string) error {
 		}
 
 		if err != nil {
-			aggregatedErr = multierr.Append(aggregatedErr, err)
+			restoreErr = err
 			break
 		}
+		if hdr.Name == bundleMarker {
+			// every bundled path is extracted to its own location
+			target = bundleTarget
+			continue
+		}
+		if fPath, err = target(hdr.Name); err != nil {
+			restoreErr = err
+			break
+		}
+
 		info := hdr.FileInfo()
 		if info.IsDir() {
-			dirPath := filepath.Join(dst, hdr.Name)
+			dirPath := fPath
 			if err = os.MkdirAll(dirPath, hdr.FileInfo().Mode()); err != nil {
-				aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("error creating dir %q: %v", dirPath, err))
+				restoreErr = fmt.Errorf("error creating dir %q: %v", dirPath, err)
 				break
 			}
 			fInfos = append(fInfos, &FileInfo{dirPath, 0, info.ModTime().UnixNano(), info.Mode().String()})
 		} else {
+			_ = os.MkdirAll(filepath.Dir(fPath), DefaultFilePermission)
 			if hdr.Typeflag == tar.TypeSymlink {
-				fPath := filepath.Join(dst, hdr.Name)
 				source := hdr.Linkname
 
 				err = os.Symlink(source, fPath)
 				if err != nil {
-					aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("error creating symlink %q %q: %v", source, fPath, err))
+					restoreErr = fmt.Errorf("error creating symlink %q %q: %v", source, fPath, err)
 					break
 				}
 				mtime[0] = unix.NsecToTimeval(info.ModTime().UnixNano())
@@ -379,24 +421,23 @@ func Decompress(src, dst string) error {
 					break
 				}
 			} else {
-				fPath := filepath.Join(dst, hdr.Name)
-
 				file, err = os.Create(fPath)
 				if err != nil {
-					aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("error creating file %q: %v", fPath, err))
+					restoreErr = fmt.Errorf("error creating file %q: %v", fPath, err)
 					break
 				}
 				written, err = io.Copy(file, tr)
 				if err != nil {
 					file.Close()
-					aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("error writing to file %q: %v", fPath, err))
+					restoreErr = fmt.Errorf("error writing to file %q: %v", fPath, err)
 					break
 				}
 				if written != hdr.Size {
 					file.Close()
-					aggregatedErr = multierr.Append(aggregatedErr, fmt.Errorf("wrote %d bytes, expected to write %d", written, hdr.Size))
+					restoreErr = fmt.Errorf("wrote %d bytes, expected to write %d", written, hdr.Size)
 					break
 				}
+				contentSize += written
 				file.Close()
 				err = os.Chtimes(fPath, info.ModTime(), info.ModTime())
 				if err != nil {
@@ -419,7 +460,11 @@ func Decompress(src, dst string) error {
 			break
 		}
 	}
+	if info, err := srcFile.Stat(); err == nil {
+		recordArchive(info.Size(), contentSize)
+	}
 
+	// only the entries which could not be extracted fail the restore, the times and modes not set are warnings
 	logger.Warn(aggregatedErr)
-	return nil
+	return restoreErr
 }
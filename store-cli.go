@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
@@ -9,6 +13,8 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/screwdriver-cd/store-cli/sdstore"
 	"github.com/urfave/cli"
@@ -42,6 +48,14 @@ func failureExit(err error) {
 	os.Exit(1)
 }
 
+// missExit exits process with 2, used when the requested item is not in the store
+func missExit(err error) {
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "MISS: %v\n", err)
+	}
+	os.Exit(2)
+}
+
 // IsEnableExpectHeader checks the SD_ENABLE_EXPECT_HEADER environment variable.
 // It returns true if the variable is set to "true", otherwise it returns false.
 func IsEnableExpectHeader() bool {
@@ -78,24 +92,29 @@ func skipCache(storeType, scope, action string) bool {
 	return false
 }
 
-// makeURL creates the fully-qualified url for a given Store path
-func makeURL(storeType, scope, key string) (*url.URL, error) {
-	storeURL := os.Getenv("SD_STORE_URL")
-	var scopeEnv string
+// scopeID returns the id of the event, job or pipeline the scope refers to
+func scopeID(scope string) string {
 	switch scope {
 	case "event":
-		scopeEnv = os.Getenv("SD_EVENT_ID")
+		return os.Getenv("SD_EVENT_ID")
 	case "job":
 		// use real job id if current job is a PR
 		if os.Getenv("SD_PULL_REQUEST") != "" && os.Getenv("SD_PR_PARENT_JOB_ID") != "" {
-			scopeEnv = os.Getenv("SD_PR_PARENT_JOB_ID")
-		} else {
-			scopeEnv = os.Getenv("SD_JOB_ID")
+			return os.Getenv("SD_PR_PARENT_JOB_ID")
 		}
+		return os.Getenv("SD_JOB_ID")
 	case "pipeline":
-		scopeEnv = os.Getenv("SD_PIPELINE_ID")
+		return os.Getenv("SD_PIPELINE_ID")
 	}
 
+	return ""
+}
+
+// makeURL creates the fully-qualified url for a given Store path
+func makeURL(storeType, scope, key string) (*url.URL, error) {
+	storeURL := os.Getenv("SD_STORE_URL")
+	scopeEnv := scopeID(scope)
+
 	var path string
 	switch storeType {
 	case "cache":
@@ -111,9 +130,7 @@ func makeURL(storeType, scope, key string) (*url.URL, error) {
 		encoded := url.PathEscape(key)
 		path = "caches/" + scope + "s/" + scopeEnv + "/" + encoded
 	case "artifact":
-		key = strings.TrimPrefix(key, "./")
-		encoded := url.PathEscape(key)
-		path = "builds/" + os.Getenv("SD_BUILD_ID") + "/ARTIFACTS/" + encoded
+		path = artifactPath(os.Getenv("SD_BUILD_ID"), key)
 	case "log":
 		path = "builds/" + os.Getenv("SD_BUILD_ID") + "-" + key
 	default:
@@ -129,105 +146,494 @@ func makeURL(storeType, scope, key string) (*url.URL, error) {
 	return url.Parse(fullpath)
 }
 
-func get(storeType, scope, key string, timeout int) error {
+// artifactPath returns the Store path of the artifact key of the build buildID
+func artifactPath(buildID, key string) string {
+	key = strings.TrimRight(strings.TrimPrefix(key, "./"), "/")
+	encoded := url.PathEscape(key)
+
+	return "builds/" + buildID + "/ARTIFACTS/" + encoded
+}
+
+// makeBuildArtifactURL creates the fully-qualified url of the artifact key of another build
+func makeBuildArtifactURL(buildID, key string) (*url.URL, error) {
+	if _, err := strconv.ParseInt(buildID, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid build id %s", buildID)
+	}
+
+	return url.Parse(os.Getenv("SD_STORE_URL") + artifactPath(buildID, key))
+}
+
+// resolveBuildID returns the build to get artifacts from: buildID, the parent build of the job fromJob,
+// or an empty string for the current build
+func resolveBuildID(buildID, fromJob string, timeout int) (string, error) {
+	if buildID != "" && fromJob != "" {
+		return "", fmt.Errorf("--build-id and --from-job cannot be used together")
+	}
+	if fromJob == "" {
+		return buildID, nil
+	}
+
+	currentBuildID := os.Getenv("SD_BUILD_ID")
+	parents, err := sdstore.GetParentBuilds(os.Getenv("SD_API_URL"), os.Getenv("SD_TOKEN"), currentBuildID, MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the parent builds of build %s: %v", currentBuildID, err)
+	}
+	buildID, err = parents.BuildID(os.Getenv("SD_PIPELINE_ID"), fromJob)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Getting artifacts of job %s from build %s", fromJob, buildID)
 
-	if strings.ToLower(storeType) == "cache" && CacheStrategy == "disk" {
-		return sdstore.Cache2Disk("get", scope, key, CacheMaxSizeInMB)
+	return buildID, nil
+}
+
+// makeListURL creates the fully-qualified url listing the items of a given type and scope
+func makeListURL(storeType, scope string) (*url.URL, error) {
+	storeURL := os.Getenv("SD_STORE_URL")
+
+	var path string
+	switch storeType {
+	case "cache":
+		if scope == "event" || scope == "job" || scope == "pipeline" {
+			path = "caches/" + scope + "s/" + scopeID(scope) + "/"
+		}
+	case "artifact":
+		path = "builds/" + os.Getenv("SD_BUILD_ID") + "/ARTIFACTS/"
+	}
+
+	if len(path) == 0 {
+		return nil, fmt.Errorf("invalid parameters")
+	}
+
+	return url.Parse(fmt.Sprintf("%s%s", storeURL, path))
+}
+
+// newBackend creates the cache backend selected with SD_CACHE_STRATEGY
+func newBackend(timeout int) (sdstore.Backend, error) {
+	return sdstore.NewBackend(CacheStrategy, sdstore.BackendConfig{
+		Token:            os.Getenv("SD_TOKEN"),
+		StoreURL:         os.Getenv("SD_STORE_URL"),
+		MaxRetries:       MAX_RETRIES,
+		HTTPTimeout:      timeout,
+		RetryWaitMin:     RETRY_WAIT_MIN,
+		RetryWaitMax:     RETRY_WAIT_MAX,
+		UseExpectHeader:  IsEnableExpectHeader(),
+		CacheMaxSizeInMB: CacheMaxSizeInMB,
+		ScopeID:          scopeID,
+	})
+}
+
+// splitKeys splits a comma separated list of cache keys, ignoring the commas of {hash:...} templates
+func splitKeys(list string) []string {
+	var (
+		keys  []string
+		depth int
+		start int
+	)
+	for i, c := range list + "," {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			if key := strings.TrimSpace(list[start:i]); key != "" {
+				keys = append(keys, key)
+			}
+			start = i + 1
+		}
+	}
+
+	return keys
+}
+
+// bundlePaths returns the paths bundled in the cache of path, nil when path is cached as is. The paths given as
+// several arguments are bundled in one archive stored under their comma separated list, as is a single glob
+// pattern matching files
+func bundlePaths(path string, bundle bool) []string {
+	if bundle {
+		return splitKeys(path)
+	}
+	if sdstore.IsBundle([]string{path}) {
+		return []string{path}
+	}
+
+	return nil
+}
+
+// hasFiles returns true when dir holds any file, in it or in its subdirectories
+func hasFiles(dir string) bool {
+	found := false
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			found = true
+			return io.EOF
+		}
+		return nil
+	})
+
+	return found
+}
+
+// cacheCandidates returns the caches get tries in turn: key, then each of restoreKeys, each of them
+// in every scope of the comma separated list of scopes. Every candidate is restored at the path of key,
+// keyFiles are only hashed in key
+func cacheCandidates(scopes, key string, restoreKeys []string, keyFiles string) ([]sdstore.Candidate, error) {
+	var scopeList []string
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopeList = append(scopeList, scope)
+		}
+	}
+	if len(scopeList) == 0 {
+		scopeList = []string{scopes}
+	}
+
+	path, key, err := sdstore.ResolveKey(key, keyFiles)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{key}
+	for _, restoreKey := range restoreKeys {
+		_, restoreKey, err = sdstore.ResolveKey(restoreKey, "")
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, restoreKey)
+	}
+
+	var candidates []sdstore.Candidate
+	for _, candidateKey := range keys {
+		for _, scope := range scopeList {
+			candidates = append(candidates, sdstore.Candidate{Scope: scope, Key: candidateKey, Path: path})
+		}
+	}
+
+	return candidates, nil
+}
+
+func get(storeType, scope, key string, bundle bool, timeout int, verify bool, restoreKeys []string, keyFiles string, buildID string, output string) error {
+	if buildID != "" && storeType != "artifact" {
+		return fmt.Errorf("--build-id and --from-job only apply to artifacts")
+	}
+
+	if storeType == "cache" {
+		if output != "" {
+			return fmt.Errorf("--output does not apply to caches, they are restored at their path")
+		}
+
+		backend, err := newBackend(timeout)
+		if err != nil {
+			return err
+		}
+
+		candidates, err := cacheCandidates(scope, key, restoreKeys, keyFiles)
+		if err != nil {
+			return err
+		}
+		path := candidates[0].Path
+		report.Scope, report.Key, report.Path = candidates[0].Scope, candidates[0].Key, path
+		var stagingDir string
+		// a single path is only bundled when its glob pattern matches, which cannot be told before restoring it
+		if bundle || sdstore.IsPattern(path) {
+			// a bundle is extracted to the paths it was set from, its archive is only staged in a temporary directory
+			if stagingDir, err = ioutil.TempDir("", "store-cli-bundle"); err != nil {
+				return err
+			}
+			defer os.RemoveAll(stagingDir)
+			for i := range candidates {
+				candidates[i].Path = filepath.Join(stagingDir, "bundle")
+			}
+		}
+
+		hit, err := sdstore.GetFirst(backend, candidates)
+		if err == nil && stagingDir != "" && hasFiles(stagingDir) {
+			// the cache of a path with glob characters, set as is, is restored at that path
+			log.Printf("%s is not a bundle, restoring it at its path", hit.Key)
+			hit.Path = path
+			hit, err = sdstore.GetFirst(backend, []sdstore.Candidate{*hit})
+		}
+		if hit != nil {
+			report.Scope, report.Key = hit.Scope, hit.Key
+			report.URL, _ = backend.Location(hit.Scope, hit.Key)
+		}
+		if err != nil || !verify {
+			return err
+		}
+
+		err = backend.Verify(hit.Scope, hit.Key, hit.Path)
+		if errors.Is(err, sdstore.ErrNotSupported) {
+			log.Printf("Skipping verification of %s, not supported with the %s cache strategy", key, CacheStrategy)
+			return nil
+		}
+		return err
 	} else {
 		sdToken := os.Getenv("SD_TOKEN")
-		fullURL, err := makeURL(storeType, scope, key)
+		var (
+			fullURL *url.URL
+			err     error
+		)
+		if buildID != "" {
+			fullURL, err = makeBuildArtifactURL(buildID, key)
+			// artifacts of another build are written to the key path by default
+			if output == "" {
+				output = filepath.Clean(strings.TrimPrefix(key, "./"))
+			}
+		} else {
+			fullURL, err = makeURL(storeType, scope, key)
+		}
 
 		if err != nil {
 			return err
 		}
 		store := sdstore.NewStore(sdToken, MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
 
-		var toExtract bool
+		report.URL = fullURL.String()
+		switch output {
+		case "":
+			err = store.Download(fullURL, false)
+		case "-":
+			err = store.DownloadToWriter(fullURL, os.Stdout)
+		default:
+			report.Path = outputPath(output, key)
+			err = store.DownloadTo(fullURL, report.Path, false)
+		}
+		if err != nil {
+			return err
+		}
 
-		if storeType == "cache" {
-			toExtract = true
-		} else {
-			toExtract = false
+		if verify {
+			log.Printf("Skipping verification of %s, only caches can be verified", key)
 		}
 
-		err = store.Download(fullURL, toExtract)
+		return nil
+	}
+}
 
+// getPolicy applies --fail-on-miss and --ignore-errors to the error of get. A missing cache is only logged,
+// unless failOnMiss, while a missing artifact or log is always returned. Other errors are only logged with ignoreErrors
+func getPolicy(storeType string, err error, failOnMiss, ignoreErrors bool) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sdstore.ErrNotFound):
+		if storeType == "cache" && !failOnMiss {
+			log.Printf("Cache miss: %v", err)
+			return nil
+		}
+		return err
+	case ignoreErrors:
+		log.Printf("Ignoring error of get: %v", err)
+		report.Error = err.Error()
+		return nil
+	default:
 		return err
 	}
 }
 
-func set(storeType, scope, filePath string, timeout int) error {
+// excludePatterns returns the exclude patterns of the config file and of .storeignore in the current directory,
+// followed by the comma separated patterns of exclude, which take precedence
+func excludePatterns(exclude string) ([]string, error) {
+	ignored, err := sdstore.ReadStoreIgnore(sdstore.StoreIgnoreFile)
+	if err != nil {
+		return nil, err
+	}
+	patterns := append(append([]string{}, ConfigExcludes...), ignored...)
+
+	for _, pattern := range strings.Split(exclude, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns, nil
+}
+
+// outputPath returns the file to write the item key to. When output is a directory, the item is written in it
+func outputPath(output, key string) string {
+	if info, err := os.Stat(output); strings.HasSuffix(output, "/") || (err == nil && info.IsDir()) {
+		return filepath.Join(output, filepath.Base(key))
+	}
+
+	return output
+}
+
+func set(storeType, scope, filePath string, bundle bool, timeout int, keyFiles string, ttl time.Duration, contentType string, name string, exclude string) error {
 	if skipCache(storeType, scope, "set") {
 		return nil
 	}
 
-	if strings.ToLower(storeType) == "cache" && CacheStrategy == "disk" {
-		return sdstore.Cache2Disk("set", scope, filePath, CacheMaxSizeInMB)
+	excludes, err := excludePatterns(exclude)
+	if err != nil {
+		return err
+	}
+
+	if storeType == "cache" {
+		if filePath == "-" || name != "" {
+			return fmt.Errorf("caches are stored under their path, they cannot be read from stdin or renamed with --name")
+		}
+
+		backend, err := newBackend(timeout)
+		if err != nil {
+			return err
+		}
+
+		path, key, err := sdstore.ResolveKey(filePath, keyFiles)
+		if err != nil {
+			return err
+		}
+		report.Key, report.Path = key, path
+		report.URL, _ = backend.Location(scope, key)
+
+		if contentType != "" {
+			log.Printf("Ignoring content type of %s, caches are stored as archives", filePath)
+		}
+
+		options := sdstore.SetOptions{TTL: ttl, Exclude: excludes, Paths: bundlePaths(path, bundle)}
+
+		return backend.Set(scope, key, path, options)
 	} else {
+		// the item is stored under --name when given, which is required to read it from stdin
+		key := filePath
+		if name != "" {
+			key = name
+		} else if filePath == "-" {
+			return fmt.Errorf("--name is required to read the %s from stdin", storeType)
+		}
+
 		sdToken := os.Getenv("SD_TOKEN")
-		fullURL, err := makeURL(storeType, scope, filePath)
+		fullURL, err := makeURL(storeType, scope, key)
 
 		if err != nil {
 			return err
 		}
-		store := sdstore.NewStore(sdToken, MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
-
-		var toCompress bool
-		if storeType == "cache" {
-			toCompress = true
-		} else {
-			toCompress = false
+		report.Key, report.URL = key, fullURL.String()
+		if filePath != "-" {
+			report.Path = filePath
 		}
+		store := sdstore.NewStore(sdToken, MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
 
 		// add Expect header if SD_ENABLE_EXPECT_HEADER=="true"
 		useExpectHeader := IsEnableExpectHeader()
 
-		return store.Upload(fullURL, filePath, toCompress, useExpectHeader)
+		if ttl > 0 {
+			log.Printf("Ignoring ttl of %s, only caches expire", key)
+		}
+
+		options := sdstore.UploadOptions{UseExpectHeader: useExpectHeader, ContentType: contentType, Exclude: excludes}
+		if filePath == "-" {
+			return store.UploadFromReader(fullURL, os.Stdin, options)
+		}
+
+		return store.UploadWithOptions(fullURL, filePath, options)
 	}
 
 }
 
-func remove(storeType, scope, key string, timeout int) error {
+func remove(storeType, scope, key string, timeout int, keyFiles string) error {
 	if skipCache(storeType, scope, "remove") {
 		return nil
 	}
 
-	if strings.ToLower(storeType) == "cache" && CacheStrategy == "disk" {
-		return sdstore.Cache2Disk("remove", scope, key, CacheMaxSizeInMB)
+	if storeType == "cache" {
+		backend, err := newBackend(timeout)
+		if err != nil {
+			return err
+		}
+
+		var path string
+		path, key, err = sdstore.ResolveKey(key, keyFiles)
+		if err != nil {
+			return err
+		}
+		report.Key, report.Path = key, path
+		report.URL, _ = backend.Location(scope, key)
+
+		return backend.Remove(scope, key)
 	} else {
 		sdToken := os.Getenv("SD_TOKEN")
 		store := sdstore.NewStore(sdToken, MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
 
-		if storeType == "cache" {
-			md5URL, err := makeURL(storeType, scope, fmt.Sprintf("%s%s", filepath.Clean(key), "_md5.json"))
-			if err != nil {
-				return err
-			}
+		fullURL, err := makeURL(storeType, scope, key)
 
-			err = store.Remove(md5URL)
-			if err != nil {
-				return fmt.Errorf("failed to remove file from %s: %s", md5URL.String(), err)
-			}
+		if err != nil {
+			return err
+		}
+		report.URL = fullURL.String()
+		return store.Remove(fullURL)
+	}
+}
 
-			zipURL, err := makeURL(storeType, scope, fmt.Sprintf("%s%s", filepath.Clean(key), ".zip"))
-			if err != nil {
-				return err
-			}
+func list(storeType, scope string, timeout int) ([]sdstore.ItemInfo, error) {
+	if storeType == "cache" {
+		backend, err := newBackend(timeout)
+		if err != nil {
+			return nil, err
+		}
 
-			err = store.Remove(zipURL)
-			if err != nil {
-				return fmt.Errorf("failed to remove file from %s: %s", zipURL.String(), err)
-			}
+		return backend.List(scope)
+	}
 
-			return nil
-		}
+	sdToken := os.Getenv("SD_TOKEN")
+	fullURL, err := makeListURL(storeType, scope)
+	if err != nil {
+		return nil, err
+	}
+	store := sdstore.NewStore(sdToken, MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
 
-		fullURL, err := makeURL(storeType, scope, key)
+	return store.List(fullURL)
+}
 
+func stat(storeType, scope, key string, timeout int, keyFiles string) (*sdstore.ItemInfo, error) {
+	if storeType == "cache" {
+		backend, err := newBackend(timeout)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return store.Remove(fullURL)
+
+		_, key, err = sdstore.ResolveKey(key, keyFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		return backend.Stat(scope, key)
+	}
+
+	sdToken := os.Getenv("SD_TOKEN")
+	fullURL, err := makeURL(storeType, scope, key)
+	if err != nil {
+		return nil, err
+	}
+	store := sdstore.NewStore(sdToken, MAX_RETRIES, timeout, RETRY_WAIT_MIN, RETRY_WAIT_MAX)
+
+	info, err := store.Stat(fullURL)
+	if err != nil {
+		return nil, err
+	}
+	info.Key = key
+
+	return info, nil
+}
+
+// printItems writes the listed items to w as a table or as JSON
+func printItems(w io.Writer, items []sdstore.ItemInfo, format string) error {
+	switch format {
+	case "json":
+		if items == nil {
+			items = []sdstore.ItemInfo{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	case "text", "":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "KEY\tSIZE\tLAST MODIFIED")
+		for _, item := range items {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\n", item.Key, item.Size, item.LastModified.UTC().Format(time.RFC3339))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("invalid format %q, expected text or json", format)
 	}
 }
 
@@ -257,16 +663,25 @@ func main() {
 	app.Copyright = "(c) 2018 Yahoo Inc."
 	app.Version = VERSION
 
+	// the config file defaults the flags and the environment variables which are not set
+	config, err := loadConfig(findConfigFile())
+	if err == nil {
+		err = config.apply()
+	}
+	if err != nil {
+		failureExit(err)
+	}
+
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "scope",
 			Usage: "Scope of command. For example: event, build, pipeline",
-			Value: "",
+			Value: config.value("scope").Value,
 		},
 		cli.StringFlag{
 			Name:  "type",
 			Usage: "Type of the command. For example: cache, artifacts, steps",
-			Value: "stable",
+			Value: config.value("type").Value,
 		},
 		cli.StringFlag{
 			Name:  "timeout",
@@ -275,75 +690,287 @@ func main() {
 		},
 	}
 
+	keyFilesFlag := cli.StringFlag{
+		Name:  "key-files",
+		Usage: "Comma separated files (or glob patterns) whose hash is added to the key of a cache, e.g. go.mod,go.sum",
+	}
+
+	formatFlag := cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format. json prints the result of the command to stdout and everything else to stderr. For example: text, json",
+	}
+
+	// --output is an alias of --format for set and remove, get already has an --output flag
+	resultFormatFlag := cli.StringFlag{
+		Name:  "format, output",
+		Usage: formatFlag.Usage,
+	}
+
+	dryRunFlag := cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Print the url or path the command resolves and what it would do, without changing anything",
+	}
+
 	app.Commands = []cli.Command{
 		{
 			Name:  "get",
 			Usage: "Get a new item from the store",
 			Action: func(c *cli.Context) error {
-				if len(c.Args()) != 1 {
-					return cli.ShowAppHelp(c)
-				}
 				scope := strings.ToLower(c.String("scope"))
 				storeType := strings.ToLower(c.String("type"))
-				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_DOWNLOAD_HTTP_TIMEOUT", DOWNLOAD_HTTP_TIMEOUT)
+				if len(c.Args()) == 0 || (len(c.Args()) > 2 && storeType != "cache") {
+					return cli.ShowAppHelp(c)
+				}
+				format, output := getFormat(storeType, strings.ToLower(c.String("format")), c.String("output"))
+				stdout, err := jsonOutput(format)
 				if err != nil {
 					failureExit(err)
 				}
+				report = newResult("get", storeType, scope, c.Args().Get(0))
+				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_DOWNLOAD_HTTP_TIMEOUT", DOWNLOAD_HTTP_TIMEOUT)
+				if err != nil {
+					resultExit(stdout, err)
+				}
 				key := c.Args().Get(0)
-				err = get(storeType, scope, key, timeout)
+				// get <key> <output> is the same as get <key> --output <output>, but get <path> <path>... bundles caches
+				bundle := storeType == "cache" && len(c.Args()) > 1
+				if storeType == "cache" {
+					key = sdstore.BundleKey(c.Args())
+				} else if len(c.Args()) == 2 {
+					if output != "" {
+						resultExit(stdout, fmt.Errorf("the output is given twice, as --output and as an argument"))
+					}
+					output = c.Args().Get(1)
+				}
+				if output == "-" && stdout != nil {
+					resultExit(stdout, fmt.Errorf("--format json cannot be used when writing to stdout with -"))
+				}
+				report.Key = key
+				restoreKeys := splitKeys(c.String("restore-keys"))
+				buildID, err := resolveBuildID(c.String("build-id"), c.String("from-job"), timeout)
 				if err != nil {
-					failureExit(err)
+					resultExit(stdout, err)
 				}
-				successExit()
+				if c.Bool("dry-run") {
+					dr, err := dryRun("get", storeType, scope, key, bundle, timeout, restoreKeys, c.String("key-files"), buildID, "", "")
+					dryRunExit(stdout, dr, err)
+				}
+				err = get(storeType, scope, key, bundle, timeout, c.Bool("verify"), restoreKeys, c.String("key-files"), buildID, output)
+				report.found(err)
+				resultExit(stdout, getPolicy(storeType, err, c.Bool("fail-on-miss"), c.Bool("ignore-errors")))
 				return nil
 			},
-			Flags: app.Flags,
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "verify",
+					Usage: "Verify the restored cache against the md5 of its files stored with it",
+				},
+				keyFilesFlag,
+				cli.StringFlag{
+					Name:  "restore-keys",
+					Usage: "Comma separated keys to restore when the cache is missing, tried in order after the key. --scope also accepts a comma separated list of scopes to try in order",
+				},
+				cli.StringFlag{
+					Name:  "build-id",
+					Usage: "Get an artifact of another build, written to the key path",
+				},
+				cli.StringFlag{
+					Name:  "from-job",
+					Usage: "Get an artifact of the parent build of a job, <job name> or sd@<pipeline id>:<job name>, written to the key path",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Write an artifact or log to this file or directory, or to stdout with -. For a cache, the output format like --format",
+				},
+				cli.BoolFlag{
+					Name:  "fail-on-miss",
+					Usage: "Exit with 2 when the cache is missing instead of 0",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-errors",
+					Usage: "Exit with 0 when the item cannot be restored instead of 1. A missing item is not an error",
+				},
+				formatFlag,
+				dryRunFlag,
+			}, app.Flags...),
 		},
 		{
 			Name:  "set",
 			Usage: "Put a new item to the store",
 			Action: func(c *cli.Context) error {
-				if len(c.Args()) != 1 {
+				scope := strings.ToLower(c.String("scope"))
+				storeType := strings.ToLower(c.String("type"))
+				if len(c.Args()) == 0 || (len(c.Args()) > 1 && storeType != "cache") {
 					return cli.ShowAppHelp(c)
 				}
+				stdout, err := jsonOutput(strings.ToLower(c.String("format")))
+				if err != nil {
+					failureExit(err)
+				}
+				// the paths of a cache given as several arguments are bundled in one archive
+				key, bundle := sdstore.BundleKey(c.Args()), len(c.Args()) > 1
+				report = newResult("set", storeType, scope, key)
+				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_UPLOAD_HTTP_TIMEOUT", UPLOAD_HTTP_TIMEOUT)
+				if err != nil {
+					resultExit(stdout, err)
+				}
+				ttl, err := sdstore.ParseTTL(c.String("ttl"))
+				if err != nil {
+					resultExit(stdout, err)
+				}
+				if c.Bool("dry-run") {
+					dr, err := dryRun("set", storeType, scope, key, bundle, timeout, nil, c.String("key-files"), "", c.String("name"), c.String("exclude"))
+					dryRunExit(stdout, dr, err)
+				}
+				err = set(storeType, scope, key, bundle, timeout, c.String("key-files"), ttl, c.String("content-type"), c.String("name"), c.String("exclude"))
+				resultExit(stdout, err)
+				return nil
+			},
+			Flags: append([]cli.Flag{
+				keyFilesFlag,
+				cli.StringFlag{
+					Name:  "ttl",
+					Usage: "Time the cache stays valid, e.g. 12h, 7d or 2w. get treats an expired cache as missing",
+				},
+				cli.StringFlag{
+					Name:  "content-type",
+					Usage: "Content type of an artifact or log, detected from its extension and content by default",
+				},
+				cli.StringFlag{
+					Name:  "name",
+					Usage: "Store an artifact or log under this name instead of its path. Required to read it from stdin with -",
+				},
+				cli.StringFlag{
+					Name:  "exclude",
+					Usage: "Comma separated gitignore-style patterns of files left out of a cache or directory artifact, after those of .storeignore",
+				},
+				resultFormatFlag,
+				dryRunFlag,
+			}, app.Flags...),
+		},
+		{
+			Name:  "remove",
+			Usage: "Remove an existing item from the store",
+			Action: func(c *cli.Context) error {
 				scope := strings.ToLower(c.String("scope"))
 				storeType := strings.ToLower(c.String("type"))
-				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_UPLOAD_HTTP_TIMEOUT", UPLOAD_HTTP_TIMEOUT)
+				if len(c.Args()) == 0 || (len(c.Args()) > 1 && storeType != "cache") {
+					return cli.ShowAppHelp(c)
+				}
+				stdout, err := jsonOutput(strings.ToLower(c.String("format")))
 				if err != nil {
 					failureExit(err)
 				}
-				key := c.Args().Get(0)
-				err = set(storeType, scope, key, timeout)
+				key := sdstore.BundleKey(c.Args())
+				report = newResult("remove", storeType, scope, key)
+				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_REMOVE_HTTP_TIMEOUT", REMOVE_HTTP_TIMEOUT)
+				if err != nil {
+					resultExit(stdout, err)
+				}
+				if c.Bool("dry-run") {
+					dr, err := dryRun("remove", storeType, scope, key, false, timeout, nil, c.String("key-files"), "", "", "")
+					dryRunExit(stdout, dr, err)
+				}
+				err = remove(storeType, scope, key, timeout, c.String("key-files"))
+				resultExit(stdout, err)
+				return nil
+			},
+			Flags: append([]cli.Flag{keyFilesFlag, resultFormatFlag, dryRunFlag}, app.Flags...),
+		},
+		{
+			Name:    "stat",
+			Aliases: []string{"exists"},
+			Usage:   "Check whether an item exists in the store. Exits with 0 if it exists, 2 if it is missing and 1 on error",
+			Action: func(c *cli.Context) error {
+				scope := strings.ToLower(c.String("scope"))
+				storeType := strings.ToLower(c.String("type"))
+				if len(c.Args()) == 0 || (len(c.Args()) > 1 && storeType != "cache") {
+					return cli.ShowAppHelp(c)
+				}
+				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_DOWNLOAD_HTTP_TIMEOUT", DOWNLOAD_HTTP_TIMEOUT)
+				if err != nil {
+					failureExit(err)
+				}
+				key := sdstore.BundleKey(c.Args())
+				info, err := stat(storeType, scope, key, timeout, c.String("key-files"))
+				if errors.Is(err, sdstore.ErrNotFound) {
+					missExit(err)
+				}
+				if err != nil {
+					failureExit(err)
+				}
+				err = printItems(os.Stdout, []sdstore.ItemInfo{*info}, strings.ToLower(c.String("format")))
 				if err != nil {
 					failureExit(err)
 				}
 				successExit()
 				return nil
 			},
-			Flags: app.Flags,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Output format. For example: text, json",
+					Value: "text",
+				},
+				keyFilesFlag,
+			}, app.Flags...),
 		},
 		{
-			Name:  "remove",
-			Usage: "Remove an existing item from the store",
+			Name:  "list",
+			Usage: "List the items of a scope in the store",
 			Action: func(c *cli.Context) error {
-				if len(c.Args()) != 1 {
+				if len(c.Args()) != 0 {
 					return cli.ShowAppHelp(c)
 				}
 				scope := strings.ToLower(c.String("scope"))
 				storeType := strings.ToLower(c.String("type"))
-				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_REMOVE_HTTP_TIMEOUT", REMOVE_HTTP_TIMEOUT)
+				timeout, err := getTimeout(c.String("timeout"), "SD_STORE_CLI_DOWNLOAD_HTTP_TIMEOUT", DOWNLOAD_HTTP_TIMEOUT)
 				if err != nil {
 					failureExit(err)
 				}
-				key := c.Args().Get(0)
-				err = remove(storeType, scope, key, timeout)
+				items, err := list(storeType, scope, timeout)
+				if err != nil {
+					failureExit(err)
+				}
+				err = printItems(os.Stdout, items, strings.ToLower(c.String("format")))
+				if err != nil {
+					failureExit(err)
+				}
+				successExit()
+				return nil
+			},
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Output format of the list. For example: text, json",
+					Value: "text",
+				},
+			}, app.Flags...),
+		},
+		{
+			Name:  "config",
+			Usage: fmt.Sprintf("Print the effective config and where each setting comes from: a flag, the environment, %s or the default", ConfigFile),
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 0 {
+					return cli.ShowAppHelp(c)
+				}
+				values := config.withFlags(func(flag string) (string, bool) {
+					return c.String(flag), c.IsSet(flag)
+				})
+				err := printConfig(os.Stdout, values, strings.ToLower(c.String("format")))
 				if err != nil {
 					failureExit(err)
 				}
 				successExit()
 				return nil
 			},
-			Flags: app.Flags,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Output format of the config. For example: text, json",
+					Value: "text",
+				},
+			}, app.Flags...),
 		},
 	}
 
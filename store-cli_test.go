@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/screwdriver-cd/store-cli/sdstore"
+	"github.com/screwdriver-cd/store-cli/sdstore/sdstoretest"
 )
 
 func TestMain(m *testing.M) {
@@ -101,6 +111,432 @@ func TestMakeURL(t *testing.T) {
 	}
 }
 
+func TestMakeListURL(t *testing.T) {
+	os.Setenv("SD_STORE_URL", "http://store.screwdriver.cd/v1/")
+	os.Setenv("SD_BUILD_ID", "10038")
+	os.Setenv("SD_JOB_ID", "888")
+	os.Setenv("SD_EVENT_ID", "499")
+	os.Setenv("SD_PIPELINE_ID", "100")
+	os.Setenv("SD_PULL_REQUEST", "")
+
+	testCases := []struct {
+		storeType string
+		scope     string
+		expected  string
+	}{
+		{"cache", "event", "http://store.screwdriver.cd/v1/caches/events/499/"},
+		{"cache", "job", "http://store.screwdriver.cd/v1/caches/jobs/888/"},
+		{"cache", "pipeline", "http://store.screwdriver.cd/v1/caches/pipelines/100/"},
+		{"artifact", "", "http://store.screwdriver.cd/v1/builds/10038/ARTIFACTS/"},
+	}
+
+	for _, tc := range testCases {
+		i, err := makeListURL(tc.storeType, tc.scope)
+		if err != nil {
+			t.Fatalf("Expected nil error, got %v", err)
+		}
+		if i.String() != tc.expected {
+			t.Fatalf("Expected '%s' got '%s'", tc.expected, i)
+		}
+	}
+
+	for _, tc := range [][]string{{"cache", ""}, {"cache", "build"}, {"log", "build"}} {
+		if _, err := makeListURL(tc[0], tc[1]); err == nil {
+			t.Fatalf("Expected error for %v, got nil", tc)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the expiry of a cache is read from its md5 json
+		if r.Method != "HEAD" && !strings.HasSuffix(r.URL.Path, "_md5.json") {
+			t.Errorf("Called with method %s, want HEAD", r.Method)
+		}
+		switch r.URL.EscapedPath() {
+		case "/v1/builds/10038/ARTIFACTS/report.json", "/v1/caches/events/499/%2Ftmp%2Fmycache.zip":
+			w.Header().Set("Content-Length", "42")
+			w.WriteHeader(200)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("SD_STORE_URL", server.URL+"/v1/")
+	os.Setenv("SD_BUILD_ID", "10038")
+	os.Setenv("SD_EVENT_ID", "499")
+	defer os.Setenv("SD_STORE_URL", "http://store.screwdriver.cd/v1/")
+
+	info, err := stat("artifact", "", "report.json", 10, "")
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if info.Key != "report.json" || info.Size != 42 {
+		t.Errorf("Unexpected stat result %v", info)
+	}
+
+	info, err = stat("cache", "event", "/tmp/mycache", 10, "")
+	if err != nil || info.Size != 42 {
+		t.Errorf("Expected cache to exist, got %v, %v", info, err)
+	}
+
+	_, err = stat("artifact", "", "missing.json", 10, "")
+	if !errors.Is(err, sdstore.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRemoveCacheArchives(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && (strings.HasSuffix(r.URL.Path, ".zip") || strings.HasSuffix(r.URL.Path, "_md5.json")) {
+			deleted = append(deleted, r.URL.EscapedPath())
+			w.WriteHeader(204)
+			return
+		}
+		w.WriteHeader(404)
+	}))
+	defer server.Close()
+
+	os.Setenv("SD_STORE_URL", server.URL+"/v1/")
+	os.Setenv("SD_EVENT_ID", "499")
+	defer os.Setenv("SD_STORE_URL", "http://store.screwdriver.cd/v1/")
+
+	err := remove("cache", "event", "/tmp/mycache", 10, "")
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if len(deleted) != 2 || deleted[1] != "/v1/caches/events/499/%2Ftmp%2Fmycache.zip" {
+		t.Errorf("Expected md5 and legacy zip archive to be removed, got %v", deleted)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv("SD_STORE_URL", "file://"+dir+"/")
+	os.Setenv("SD_BUILD_ID", "10038")
+	defer os.Setenv("SD_STORE_URL", "http://store.screwdriver.cd/v1/")
+
+	artifact := filepath.Join(dir, "report.json")
+	_ = os.WriteFile(artifact, []byte(`{"passed":true}`), 0644)
+
+	err := set("artifact", "", artifact, false, 10, "", 0, "", "", "")
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	storedPath := filepath.Join(dir, "builds", "10038", "ARTIFACTS", url.PathEscape(artifact))
+	if content, _ := os.ReadFile(storedPath); string(content) != `{"passed":true}` {
+		t.Errorf("Expected artifact to be stored at %s, got %q", storedPath, content)
+	}
+
+	info, err := stat("artifact", "", artifact, 10, "")
+	if err != nil || info.Size != 15 {
+		t.Errorf("Expected artifact to exist, got %v, %v", info, err)
+	}
+
+	err = remove("artifact", "", artifact, 10, "")
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	_, err = stat("artifact", "", artifact, 10, "")
+	if !errors.Is(err, sdstore.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// newFakeStore starts a fake store and points store-cli to it, as build 10038 of job 888 in event 499
+// of pipeline 100, from a temporary directory which it returns
+func newFakeStore(t *testing.T) (*sdstoretest.Server, string) {
+	t.Helper()
+	server := sdstoretest.NewServer("faketoken")
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("SD_STORE_URL", server.StoreURL())
+	t.Setenv("SD_TOKEN", "faketoken")
+	t.Setenv("SD_BUILD_ID", "10038")
+	t.Setenv("SD_EVENT_ID", "499")
+	t.Setenv("SD_JOB_ID", "888")
+	t.Setenv("SD_PIPELINE_ID", "100")
+	t.Setenv("SD_PULL_REQUEST", "")
+
+	return server, dir
+}
+
+func TestCacheWithFakeStore(t *testing.T) {
+	server, dir := newFakeStore(t)
+
+	cache := filepath.Join(dir, "cache")
+	_ = os.MkdirAll(cache, 0755)
+	_ = os.WriteFile(filepath.Join(cache, "file"), []byte("cached"), 0644)
+
+	if err := set("cache", "job", cache, false, 10, "", 0, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	items, err := list("cache", "job", 10)
+	if err != nil || len(items) != 1 || items[0].Key != cache {
+		t.Errorf("Expected the cache to be listed, got %v, %v", items, err)
+	}
+
+	_ = os.RemoveAll(cache)
+	if err := get("cache", "job", cache, false, 10, true, nil, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(cache, "file")); string(content) != "cached" {
+		t.Errorf("Restored file contains %q, want %q", content, "cached")
+	}
+
+	if err := remove("cache", "job", cache, 10, ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if paths := server.Paths(); len(paths) != 0 {
+		t.Errorf("Expected the cache to be removed, got %v", paths)
+	}
+}
+
+func TestCacheBundleWithFakeStore(t *testing.T) {
+	server, _ := newFakeStore(t)
+
+	files := map[string]string{"packages/a/node_modules/a.js": "a", "packages/b/node_modules/b.js": "b", "vendor/v.go": "v"}
+	for name, content := range files {
+		_ = os.MkdirAll(filepath.Dir(name), 0755)
+		_ = os.WriteFile(name, []byte(content), 0644)
+	}
+	_ = os.WriteFile("go.sum", []byte("sum"), 0644)
+
+	key := "packages/*/node_modules,vendor@{hash:go.sum}"
+	if err := set("cache", "job", key, true, 10, "", 0, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	_ = os.RemoveAll("packages")
+	_ = os.RemoveAll("vendor")
+	if err := get("cache", "job", key, true, 10, true, nil, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	for name, content := range files {
+		if restored, _ := os.ReadFile(name); string(restored) != content {
+			t.Errorf("Restored file %s contains %q, want %q", name, restored, content)
+		}
+	}
+
+	if err := remove("cache", "job", key, 10, ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if paths := server.Paths(); len(paths) != 0 {
+		t.Errorf("Expected the bundle to be removed, got %v", paths)
+	}
+}
+
+func TestCacheSinglePathWithBundleCharacters(t *testing.T) {
+	newFakeStore(t)
+
+	// a single path with commas or glob characters matching nothing is cached as is
+	for _, path := range []string{"cache,v1", "reports[1]"} {
+		_ = os.MkdirAll(path, 0755)
+		_ = os.WriteFile(filepath.Join(path, "file"), []byte(path), 0644)
+		if err := set("cache", "job", path, false, 10, "", 0, "", "", ""); err != nil {
+			t.Fatalf("Expected nil error, got %v", err)
+		}
+
+		_ = os.RemoveAll(path)
+		if err := get("cache", "job", path, false, 10, true, nil, "", "", ""); err != nil {
+			t.Fatalf("Expected nil error, got %v", err)
+		}
+		if restored, _ := os.ReadFile(filepath.Join(path, "file")); string(restored) != path {
+			t.Errorf("Restored file of %s contains %q", path, restored)
+		}
+	}
+}
+
+func TestCacheExcludeWithFakeStore(t *testing.T) {
+	newFakeStore(t)
+
+	_ = os.MkdirAll("node_modules/.cache", 0755)
+	_ = os.WriteFile("node_modules/a.js", []byte("a"), 0644)
+	_ = os.WriteFile("node_modules/debug.log", []byte("log"), 0644)
+	_ = os.WriteFile("node_modules/.cache/tmp", []byte("tmp"), 0644)
+	_ = os.WriteFile(sdstore.StoreIgnoreFile, []byte(".cache/\n"), 0644)
+
+	if err := set("cache", "job", "node_modules", false, 10, "", 0, "", "", "*.log"); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	// the excluded files left in place are not verified
+	_ = os.Remove("node_modules/a.js")
+	if err := get("cache", "job", "node_modules", false, 10, true, nil, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if content, _ := os.ReadFile("node_modules/a.js"); string(content) != "a" {
+		t.Errorf("Restored file contains %q, want %q", content, "a")
+	}
+
+	_ = os.RemoveAll("node_modules")
+	if err := get("cache", "job", "node_modules", false, 10, true, nil, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	for _, name := range []string{"node_modules/debug.log", "node_modules/.cache"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be excluded, got %v", name, err)
+		}
+	}
+}
+
+func TestCacheCandidates(t *testing.T) {
+	got, err := cacheCandidates("event, pipeline", "node_modules", []string{"vendor"}, "")
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	want := []sdstore.Candidate{
+		{Scope: "event", Key: "node_modules", Path: "node_modules"},
+		{Scope: "pipeline", Key: "node_modules", Path: "node_modules"},
+		{Scope: "event", Key: "vendor", Path: "node_modules"},
+		{Scope: "pipeline", Key: "vendor", Path: "node_modules"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("cacheCandidates() = %v, want %v", got, want)
+	}
+
+	if got, _ := cacheCandidates("job", "node_modules", nil, ""); len(got) != 1 || got[0].Scope != "job" {
+		t.Errorf("Expected a single candidate, got %v", got)
+	}
+
+	if _, err := cacheCandidates("job", "node_modules@{hash:missing.lock}", nil, ""); err == nil {
+		t.Error("Expected an error for a missing key file")
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	got := splitKeys("node_modules@{hash:package.json,package-lock.json}, node_modules,,vendor")
+	want := []string{"node_modules@{hash:package.json,package-lock.json}", "node_modules", "vendor"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("splitKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestCacheKeyFiles(t *testing.T) {
+	server, _ := newFakeStore(t)
+
+	_ = os.MkdirAll("node_modules", 0755)
+	_ = os.WriteFile(filepath.Join("node_modules", "file"), []byte("v1"), 0644)
+	_ = os.WriteFile("package-lock.json", []byte("lock v1"), 0644)
+	if err := set("cache", "event", "node_modules@{hash:package-lock.json}", false, 10, "", 0, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	_ = os.WriteFile(filepath.Join("node_modules", "file"), []byte("v2"), 0644)
+	_ = os.WriteFile("package-lock.json", []byte("lock v2"), 0644)
+	if err := set("cache", "event", "node_modules", false, 10, "package-lock.json", 0, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+
+	// one entry for each version of the lockfile
+	var archives []string
+	for _, path := range server.Paths() {
+		if strings.HasSuffix(path, sdstore.CompressFormatTarZst) {
+			archives = append(archives, path)
+		}
+	}
+	if len(archives) != 2 || !strings.HasPrefix(archives[0], "caches/events/499/node_modules@") {
+		t.Fatalf("Expected 2 archives, got %v", archives)
+	}
+
+	_ = os.WriteFile("package-lock.json", []byte("lock v1"), 0644)
+	_ = os.RemoveAll("node_modules")
+	if err := get("cache", "event", "node_modules", false, 10, true, nil, "package-lock.json", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join("node_modules", "file")); string(content) != "v1" {
+		t.Errorf("Restored file contains %q, want %q", content, "v1")
+	}
+}
+
+func TestGetCacheFallback(t *testing.T) {
+	_, dir := newFakeStore(t)
+
+	cache := filepath.Join(dir, "cache")
+	_ = os.MkdirAll(cache, 0755)
+	_ = os.WriteFile(filepath.Join(cache, "file"), []byte("cached"), 0644)
+	if err := set("cache", "pipeline", cache, false, 10, "", 0, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	_ = os.RemoveAll(cache)
+
+	if err := get("cache", "event,pipeline", cache, false, 10, false, nil, "", "", ""); err != nil {
+		t.Fatalf("Expected the pipeline cache to be restored, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(cache, "file")); string(content) != "cached" {
+		t.Errorf("Restored file contains %q, want %q", content, "cached")
+	}
+
+	err := get("cache", "event,job", cache, false, 10, false, []string{"other"}, "", "", "")
+	if !errors.Is(err, sdstore.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGetPolicy(t *testing.T) {
+	miss := fmt.Errorf("cache not found: %w", sdstore.ErrNotFound)
+	failure := errors.New("connection refused")
+	defer func() { report = &Result{} }()
+
+	testCases := []struct {
+		storeType    string
+		err          error
+		failOnMiss   bool
+		ignoreErrors bool
+		expected     error
+	}{
+		{"cache", nil, true, false, nil},
+		{"cache", miss, false, false, nil},
+		{"cache", miss, true, false, miss},
+		{"cache", miss, true, true, miss},
+		{"artifact", miss, false, false, miss},
+		{"cache", failure, false, false, failure},
+		{"cache", failure, false, true, nil},
+		{"log", failure, true, true, nil},
+	}
+
+	for _, tc := range testCases {
+		report = &Result{}
+		if err := getPolicy(tc.storeType, tc.err, tc.failOnMiss, tc.ignoreErrors); err != tc.expected {
+			t.Errorf("Expected %v for %v of a %s with fail-on-miss %v and ignore-errors %v, got %v", tc.expected, tc.err, tc.storeType, tc.failOnMiss, tc.ignoreErrors, err)
+		}
+		if tc.ignoreErrors && tc.err == failure && report.Error != failure.Error() {
+			t.Errorf("Expected the ignored error in the result, got %q", report.Error)
+		}
+	}
+}
+
+func TestPrintItems(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []sdstore.ItemInfo{{Key: "/tmp/node_modules", Size: 1024, LastModified: modified}}
+
+	var text bytes.Buffer
+	if err := printItems(&text, items, "text"); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if !strings.Contains(text.String(), "/tmp/node_modules  1024  2024-01-02T03:04:05Z") {
+		t.Errorf("Unexpected text output %q", text.String())
+	}
+
+	var jsonOutput bytes.Buffer
+	if err := printItems(&jsonOutput, items, "json"); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	var got []sdstore.ItemInfo
+	if err := json.Unmarshal(jsonOutput.Bytes(), &got); err != nil || len(got) != 1 || got[0].Key != "/tmp/node_modules" {
+		t.Errorf("Unexpected json output %q", jsonOutput.String())
+	}
+
+	if err := printItems(&text, items, "xml"); err == nil {
+		t.Errorf("Expected error for invalid format, got nil")
+	}
+}
+
 func TestGetTimeout(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -251,3 +687,132 @@ func TestIsEnableExpectHeader(t *testing.T) {
 
 	os.Unsetenv("SD_ENABLE_EXPECT_HEADER")
 }
+
+func TestGetArtifactOfOtherBuild(t *testing.T) {
+	server, dir := newFakeStore(t)
+	server.Put("builds/555/ARTIFACTS/reports%2Fsummary.json", []byte(`{"passed":true}`))
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4/builds/10038" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`{"parentBuilds": {"123": {"eventId": 9, "jobs": {"main": 555}}}}`))
+	}))
+	defer api.Close()
+
+	t.Setenv("SD_API_URL", api.URL+"/v4/")
+	t.Setenv("SD_PIPELINE_ID", "123")
+
+	buildID, err := resolveBuildID("", "main", 10)
+	if err != nil || buildID != "555" {
+		t.Fatalf("Expected build 555, got %s, %v", buildID, err)
+	}
+	if _, err := resolveBuildID("555", "main", 10); err == nil {
+		t.Errorf("Expected an error for both --build-id and --from-job")
+	}
+
+	if err := get("artifact", "", "./reports/summary.json", false, 10, false, nil, "", buildID, ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "reports", "summary.json")); string(content) != `{"passed":true}` {
+		t.Errorf("Downloaded artifact contains %q", content)
+	}
+
+	if err := get("artifact", "", "missing.json", false, 10, false, nil, "", "555", ""); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := get("cache", "event", "node_modules", false, 10, false, nil, "", "555", ""); err == nil {
+		t.Errorf("Expected an error for a cache of another build")
+	}
+}
+
+func TestGetOutput(t *testing.T) {
+	server, dir := newFakeStore(t)
+	server.Put("builds/10038/ARTIFACTS/foo%2Freport.json", []byte(`{"passed":true}`))
+	server.Put("builds/10038-step-test", []byte("test log"))
+
+	output := filepath.Join(dir, "report.json")
+	if err := get("artifact", "", "foo/report.json", false, 10, false, nil, "", "", output); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if content, _ := os.ReadFile(output); string(content) != `{"passed":true}` {
+		t.Errorf("Downloaded artifact contains %q", content)
+	}
+
+	outputDir := filepath.Join(dir, "reports") + "/"
+	if err := get("artifact", "", "foo/report.json", false, 10, false, nil, "", "", outputDir); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(outputDir, "report.json")); string(content) != `{"passed":true}` {
+		t.Errorf("Downloaded artifact contains %q", content)
+	}
+
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := get("log", "", "step-test", false, 10, false, nil, "", "", "-")
+	w.Close()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	var printed bytes.Buffer
+	_, _ = printed.ReadFrom(r)
+	if printed.String() != "test log" {
+		t.Errorf("Printed log %q, want %q", printed.String(), "test log")
+	}
+
+	if err := get("cache", "event", "node_modules", false, 10, false, nil, "", "", "-"); err == nil {
+		t.Errorf("Expected an error for the output of a cache")
+	}
+}
+
+func TestSetContentType(t *testing.T) {
+	server, dir := newFakeStore(t)
+
+	report := filepath.Join(dir, "report.html")
+	_ = os.WriteFile(report, []byte("<html></html>"), 0644)
+
+	if err := set("artifact", "", report, false, 10, "", 0, "", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	item, ok := server.Get("builds/10038/ARTIFACTS/" + url.PathEscape(report))
+	if !ok || !strings.HasPrefix(item.ContentType, "text/html") {
+		t.Errorf("Expected the report to be uploaded as text/html, got %v", item)
+	}
+
+	if err := set("artifact", "", report, false, 10, "", 0, "text/plain", "", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	if item, _ := server.Get("builds/10038/ARTIFACTS/" + url.PathEscape(report)); item.ContentType != "text/plain" {
+		t.Errorf("Expected the content type to be overridden, got %s", item.ContentType)
+	}
+}
+
+func TestSetFromStdin(t *testing.T) {
+	server, _ := newFakeStore(t)
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	r, w, _ := os.Pipe()
+	os.Stdin = r
+	go func() {
+		w.Write([]byte("generated report"))
+		w.Close()
+	}()
+
+	if err := set("artifact", "", "-", false, 10, "", 0, "", "reports/build.txt", ""); err != nil {
+		t.Fatalf("Expected nil error, got %v", err)
+	}
+	item, ok := server.Get("builds/10038/ARTIFACTS/" + url.PathEscape("reports/build.txt"))
+	if !ok || string(item.Body) != "generated report" || !strings.HasPrefix(item.ContentType, "text/plain") {
+		t.Errorf("Unexpected item uploaded from stdin %v", item)
+	}
+
+	if err := set("artifact", "", "-", false, 10, "", 0, "", "", ""); err == nil {
+		t.Errorf("Expected an error without --name")
+	}
+	if err := set("cache", "event", "-", false, 10, "", 0, "", "node_modules", ""); err == nil {
+		t.Errorf("Expected an error for a cache read from stdin")
+	}
+}